@@ -0,0 +1,14 @@
+// Package chilimiter liga o rate limiter a roteadores chi.
+package chilimiter
+
+import (
+	"net/http"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// Middleware devolve o rate limiter no formato de middleware do chi, para uso
+// com r.Use ou r.With. O chi usa http.Handler, então isso é apenas m.Handle.
+func Middleware(m *middleware.RateLimiterMiddleware) func(http.Handler) http.Handler {
+	return m.Handle
+}
@@ -0,0 +1,33 @@
+// Package echolimiter liga o rate limiter a aplicações echo.
+package echolimiter
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// Middleware devolve o rate limiter como echo.MiddlewareFunc. Requisições
+// barradas recebem a resposta de rejeição sem chegar ao handler.
+func Middleware(m *middleware.RateLimiterMiddleware) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			admission, ok := m.Admit(c.Response(), c.Request())
+			if !ok {
+				return nil
+			}
+			defer admission.Release()
+			c.SetRequest(admission.Request)
+			err := next(c)
+			if err != nil && admission.NeedsResponse() {
+				// O status de um erro só é conhecido depois que o error
+				// handler escreve a resposta. O erro já foi tratado, então
+				// não volta para o echo, que o trataria de novo.
+				c.Error(err)
+				err = nil
+			}
+			admission.Finish(c.Response().Status, c.Response().Size)
+			return err
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package fiberlimiter liga o rate limiter a aplicações fiber.
+package fiberlimiter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// Middleware devolve o rate limiter como fiber.Handler. A requisição do
+// fasthttp é convertida para *http.Request só para a decisão; as liberadas
+// seguem com c.UserContext carregando o span do middleware.
+func Middleware(m *middleware.RateLimiterMiddleware) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		r, err := adaptor.ConvertRequest(c, true)
+		if err != nil {
+			return err
+		}
+		w := &responseWriter{c: c, header: make(http.Header)}
+		admission, ok := m.Admit(w, r)
+		w.copyHeaders()
+		if !ok {
+			return nil
+		}
+
+		defer admission.Release()
+		c.SetUserContext(admission.Request.Context())
+		err = c.Next()
+		status := c.Response().StatusCode()
+		if err != nil {
+			// O error handler do fiber só escreve a resposta depois.
+			status = fiber.StatusInternalServerError
+			var fiberErr *fiber.Error
+			if errors.As(err, &fiberErr) {
+				status = fiberErr.Code
+			}
+		}
+		admission.Finish(status, int64(len(c.Response().Body())))
+		return err
+	}
+}
+
+// responseWriter escreve na resposta do fiber o que o middleware escreveria
+// num http.ResponseWriter: os cabeçalhos de limite e a rejeição.
+type responseWriter struct {
+	c           *fiber.Ctx
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.copyHeaders()
+	w.c.Status(status)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.c.Write(b)
+}
+
+func (w *responseWriter) copyHeaders() {
+	for key, values := range w.header {
+		w.c.Response().Header.Del(key)
+		for _, value := range values {
+			w.c.Response().Header.Add(key, value)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+// Package ginlimiter liga o rate limiter a aplicações gin.
+package ginlimiter
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// Middleware devolve o rate limiter como gin.HandlerFunc. Requisições barradas
+// recebem a resposta de rejeição e o contexto é abortado; as liberadas seguem
+// com c.Request carregando o span do middleware.
+func Middleware(m *middleware.RateLimiterMiddleware) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admission, ok := m.Admit(c.Writer, c.Request)
+		if !ok {
+			c.Abort()
+			return
+		}
+		defer admission.Release()
+		c.Request = admission.Request
+		c.Next()
+		admission.Finish(c.Writer.Status(), int64(max(c.Writer.Size(), 0)))
+	}
+}
@@ -1,31 +1,506 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	RedisAddr      string
-	IPLimit        int
-	TokenLimit     int
-	IPDuration     time.Duration
-	IPBlockTime    time.Duration
-	TokenBlockTime time.Duration
-	ServerPort     string
+	RedisAddr       string
+	IPLimit         int
+	TokenLimit      int
+	IPDuration      time.Duration
+	IPBlockTime     time.Duration
+	TokenBlockTime  time.Duration
+	ServerPort      string
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	ReadyTimeout    time.Duration
+	GRPCPort        string
+	// AdminPort serve os endpoints internos (/ext_authz/, /check e /admin/*),
+	// fora da porta pública repassada ao upstream.
+	AdminPort      string
+	Strategy       string
+	SyncInterval   time.Duration
+	File           string
+	ReloadInterval time.Duration
+	Policies       bool
+	PolicyCacheTTL time.Duration
+	Plans          map[string]Plan
+	Tokens         map[string]string
+	Allowlist      []string
+	Denylist       []string
+	// TrustedProxies são os proxies cujos X-Real-IP e X-Forwarded-For
+	// identificam o cliente; vazio, os cabeçalhos valem de qualquer origem.
+	TrustedProxies []string
+	Upstreams      map[string]string
+	Routes         []Route
+	IPWindows      []Window
+	TokenWindows   []Window
+	IPQuota        Quota
+	TokenQuota     Quota
+	// IPConcurrency e TokenConcurrency limitam as requisições em andamento
+	// por chave; 0 desativa o limite.
+	IPConcurrency    int
+	TokenConcurrency int
+	ConcurrencyLease time.Duration
+	// QueueMaxDelay é quanto uma requisição acima do limite pode esperar
+	// antes do 429 (0 desativa a fila); QueueSize limita quantas esperam.
+	QueueMaxDelay  time.Duration
+	QueueSize      int
+	AdminToken     string
+	UsageReporting bool
+	UsageRetention time.Duration
+	// UsageFoldUnknownTokens soma os tokens sem plano nem política dinâmica
+	// numa só linha do relatório de uso.
+	UsageFoldUnknownTokens bool
+	LogLevel               string
+	LogSampleRate          float64
+	// LogKeyHashSecret é o segredo do HMAC do key_hash; vazio sorteia um por
+	// processo.
+	LogKeyHashSecret string
+	TracingExporter  string
+	// LimitedTemplate é o caminho de um html/template para a página de
+	// rejeição servida aos navegadores; vazio usa a página padrão.
+	LimitedTemplate string
+	Skip            Skip
 }
 
-func Load() *Config {
-	return &Config{
-		RedisAddr:      getEnv("REDIS_ADDR", "localhost:6379"),
-		IPLimit:        getEnvAsInt("IP_LIMIT", 5),
-		TokenLimit:     getEnvAsInt("TOKEN_LIMIT", 10),
-		IPDuration:     getEnvAsDuration("IP_DURATION", "1s"),
-		IPBlockTime:    getEnvAsDuration("IP_BLOCK_TIME", "5m"),
-		TokenBlockTime: getEnvAsDuration("TOKEN_BLOCK_TIME", "6m"),
-		ServerPort:     getEnv("SERVER_PORT", "8080"),
+// Window é um limite extra aplicado junto com o principal, como "500/1m".
+type Window struct {
+	Limit    int
+	Duration time.Duration
+}
+
+// Route define o custo das requisições que casam com Method e Path (prefixo).
+type Route struct {
+	Method           string
+	Path             string
+	Cost             int
+	QueryParam       string
+	BodyBytesPerUnit int64
+	// OnlyFailures e ResponseBytesPerUnit fazem a cobrança acontecer depois da resposta.
+	OnlyFailures         bool
+	ResponseBytesPerUnit int64
+}
+
+type Plan struct {
+	Limit     int
+	Duration  time.Duration
+	BlockTime time.Duration
+	Windows   []Window
+	Quota     Quota
+	// Concurrency substitui TokenConcurrency para os tokens do plano.
+	Concurrency int
+	// QueueMaxDelay, quando maior que zero, substitui QueueMaxDelay para os
+	// tokens do plano.
+	QueueMaxDelay time.Duration
+}
+
+// Quota é uma cota de longo prazo que zera no início de cada dia ou mês
+// (Period "daily" ou "monthly") no fuso Timezone. Limit 0 desativa a cota.
+type Quota struct {
+	Limit    int
+	Period   string
+	Timezone string
+}
+
+// Skip lista o tráfego que passa direto pelo limiter: caminhos exatos,
+// métodos, preflights de CORS, redes internas e requisições com o cabeçalho
+// de bypass assinado com BypassSecret.
+type Skip struct {
+	Paths        []string
+	Methods      []string
+	Preflight    bool
+	Networks     []string
+	BypassSecret string
+	BypassHeader string
+	BypassMaxAge time.Duration
+}
+
+// Load parte dos valores padrão, aplica o arquivo indicado em RATE_LIMIT_CONFIG
+// (quando houver) e por último as variáveis de ambiente, retornando todos os
+// erros de formato e de validação encontrados de uma só vez.
+func Load() (*Config, error) {
+	var errs []error
+
+	cfg := &Config{
+		RedisAddr:        "localhost:6379",
+		IPLimit:          5,
+		TokenLimit:       10,
+		IPDuration:       time.Second,
+		IPBlockTime:      5 * time.Minute,
+		TokenBlockTime:   6 * time.Minute,
+		ServerPort:       "8080",
+		AdminPort:        "8081",
+		ReadTimeout:      10 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		IdleTimeout:      60 * time.Second,
+		ShutdownTimeout:  15 * time.Second,
+		ReadyTimeout:     time.Second,
+		Strategy:         "exact",
+		SyncInterval:     100 * time.Millisecond,
+		File:             os.Getenv("RATE_LIMIT_CONFIG"),
+		ReloadInterval:   5 * time.Second,
+		PolicyCacheTTL:   10 * time.Second,
+		UsageRetention:   400 * 24 * time.Hour,
+		LogLevel:         "info",
+		LogSampleRate:    0.01,
+		TracingExporter:  "none",
+		ConcurrencyLease: 30 * time.Second,
+		QueueSize:        100,
+		Skip: Skip{
+			BypassHeader: "X-RateLimit-Bypass",
+			BypassMaxAge: 5 * time.Minute,
+		},
+	}
+
+	if cfg.File != "" {
+		if err := loadFile(cfg.File, cfg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	cfg.RedisAddr = getEnv("REDIS_ADDR", cfg.RedisAddr)
+	cfg.IPLimit = getEnvAsInt("IP_LIMIT", cfg.IPLimit, &errs)
+	cfg.TokenLimit = getEnvAsInt("TOKEN_LIMIT", cfg.TokenLimit, &errs)
+	cfg.IPDuration = getEnvAsDuration("IP_DURATION", cfg.IPDuration, &errs)
+	cfg.IPBlockTime = getEnvAsDuration("IP_BLOCK_TIME", cfg.IPBlockTime, &errs)
+	cfg.TokenBlockTime = getEnvAsDuration("TOKEN_BLOCK_TIME", cfg.TokenBlockTime, &errs)
+	cfg.ServerPort = getEnv("SERVER_PORT", cfg.ServerPort)
+	cfg.ReadTimeout = getEnvAsDuration("SERVER_READ_TIMEOUT", cfg.ReadTimeout, &errs)
+	cfg.WriteTimeout = getEnvAsDuration("SERVER_WRITE_TIMEOUT", cfg.WriteTimeout, &errs)
+	cfg.IdleTimeout = getEnvAsDuration("SERVER_IDLE_TIMEOUT", cfg.IdleTimeout, &errs)
+	cfg.ShutdownTimeout = getEnvAsDuration("SHUTDOWN_TIMEOUT", cfg.ShutdownTimeout, &errs)
+	cfg.ReadyTimeout = getEnvAsDuration("READINESS_TIMEOUT", cfg.ReadyTimeout, &errs)
+	cfg.GRPCPort = getEnv("GRPC_PORT", cfg.GRPCPort)
+	cfg.AdminPort = getEnv("ADMIN_PORT", cfg.AdminPort)
+	cfg.Strategy = getEnv("LIMITER_STRATEGY", cfg.Strategy)
+	cfg.SyncInterval = getEnvAsDuration("LIMITER_SYNC_INTERVAL", cfg.SyncInterval, &errs)
+	cfg.ReloadInterval = getEnvAsDuration("CONFIG_RELOAD_INTERVAL", cfg.ReloadInterval, &errs)
+	cfg.Policies = getEnvAsBool("DYNAMIC_POLICIES", cfg.Policies, &errs)
+	cfg.PolicyCacheTTL = getEnvAsDuration("POLICY_CACHE_TTL", cfg.PolicyCacheTTL, &errs)
+	if upstream := os.Getenv("UPSTREAM_URL"); upstream != "" {
+		upstreams := map[string]string{"/": upstream}
+		for prefix, target := range cfg.Upstreams {
+			if prefix != "/" {
+				upstreams[prefix] = target
+			}
+		}
+		cfg.Upstreams = upstreams
+	}
+	// No modo proxy a resposta do upstream pode ser lenta ou vir em streaming,
+	// e a fila e as requisições longas também escrevem tarde: sem
+	// SERVER_WRITE_TIMEOUT explícito, o servidor não corta a escrita.
+	if len(cfg.Upstreams) > 0 && os.Getenv("SERVER_WRITE_TIMEOUT") == "" {
+		cfg.WriteTimeout = 0
+	}
+	cfg.Allowlist = getEnvAsList("ALLOWLIST", cfg.Allowlist)
+	cfg.Denylist = getEnvAsList("DENYLIST", cfg.Denylist)
+	cfg.TrustedProxies = getEnvAsList("TRUSTED_PROXIES", cfg.TrustedProxies)
+	cfg.Skip.Paths = getEnvAsList("SKIP_PATHS", cfg.Skip.Paths)
+	cfg.Skip.Methods = getEnvAsList("SKIP_METHODS", cfg.Skip.Methods)
+	cfg.Skip.Preflight = getEnvAsBool("SKIP_PREFLIGHT", cfg.Skip.Preflight, &errs)
+	cfg.Skip.Networks = getEnvAsList("SKIP_NETWORKS", cfg.Skip.Networks)
+	cfg.Skip.BypassSecret = getEnv("BYPASS_SECRET", cfg.Skip.BypassSecret)
+	cfg.Skip.BypassHeader = getEnv("BYPASS_HEADER", cfg.Skip.BypassHeader)
+	cfg.Skip.BypassMaxAge = getEnvAsDuration("BYPASS_MAX_AGE", cfg.Skip.BypassMaxAge, &errs)
+	cfg.IPWindows = getEnvAsWindows("IP_WINDOWS", cfg.IPWindows, &errs)
+	cfg.TokenWindows = getEnvAsWindows("TOKEN_WINDOWS", cfg.TokenWindows, &errs)
+	cfg.IPQuota = getEnvAsQuota("IP_QUOTA", cfg.IPQuota, &errs)
+	cfg.TokenQuota = getEnvAsQuota("TOKEN_QUOTA", cfg.TokenQuota, &errs)
+	if tz := os.Getenv("QUOTA_TIMEZONE"); tz != "" {
+		cfg.IPQuota.Timezone = tz
+		cfg.TokenQuota.Timezone = tz
+	}
+	cfg.IPConcurrency = getEnvAsInt("IP_CONCURRENCY", cfg.IPConcurrency, &errs)
+	cfg.TokenConcurrency = getEnvAsInt("TOKEN_CONCURRENCY", cfg.TokenConcurrency, &errs)
+	cfg.ConcurrencyLease = getEnvAsDuration("CONCURRENCY_LEASE", cfg.ConcurrencyLease, &errs)
+	cfg.QueueMaxDelay = getEnvAsDuration("QUEUE_MAX_DELAY", cfg.QueueMaxDelay, &errs)
+	cfg.QueueSize = getEnvAsInt("QUEUE_SIZE", cfg.QueueSize, &errs)
+	cfg.AdminToken = getEnv("ADMIN_TOKEN", cfg.AdminToken)
+	cfg.UsageReporting = getEnvAsBool("USAGE_REPORTING", cfg.UsageReporting, &errs)
+	cfg.UsageRetention = getEnvAsDuration("USAGE_RETENTION", cfg.UsageRetention, &errs)
+	cfg.UsageFoldUnknownTokens = getEnvAsBool("USAGE_FOLD_UNKNOWN_TOKENS", cfg.UsageFoldUnknownTokens, &errs)
+	cfg.LogLevel = getEnv("LOG_LEVEL", cfg.LogLevel)
+	cfg.LogSampleRate = getEnvAsFloat("LOG_ALLOWED_SAMPLE_RATE", cfg.LogSampleRate, &errs)
+	cfg.LogKeyHashSecret = getEnv("LOG_KEY_HASH_SECRET", cfg.LogKeyHashSecret)
+	cfg.TracingExporter = getEnv("TRACING_EXPORTER", cfg.TracingExporter)
+	cfg.LimitedTemplate = getEnv("LIMITED_HTML_TEMPLATE", cfg.LimitedTemplate)
+
+	if err := cfg.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.RedisAddr == "" {
+		errs = append(errs, errors.New("REDIS_ADDR: must not be empty"))
+	}
+	if c.IPLimit <= 0 {
+		errs = append(errs, fmt.Errorf("IP_LIMIT: must be greater than zero, got %d", c.IPLimit))
+	}
+	if c.TokenLimit <= 0 {
+		errs = append(errs, fmt.Errorf("TOKEN_LIMIT: must be greater than zero, got %d", c.TokenLimit))
+	}
+	if c.IPDuration <= 0 {
+		errs = append(errs, fmt.Errorf("IP_DURATION: must be greater than zero, got %v", c.IPDuration))
+	}
+	if c.IPBlockTime < c.IPDuration {
+		errs = append(errs, fmt.Errorf("IP_BLOCK_TIME: must not be shorter than IP_DURATION (%v), got %v", c.IPDuration, c.IPBlockTime))
+	}
+	if c.TokenBlockTime < c.IPDuration {
+		errs = append(errs, fmt.Errorf("TOKEN_BLOCK_TIME: must not be shorter than IP_DURATION (%v), got %v", c.IPDuration, c.TokenBlockTime))
+	}
+	if port, err := strconv.Atoi(c.ServerPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("SERVER_PORT: %q is not a valid port", c.ServerPort))
+	}
+	if port, err := strconv.Atoi(c.AdminPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("ADMIN_PORT: %q is not a valid port", c.AdminPort))
+	} else if c.AdminPort == c.ServerPort || c.AdminPort == c.GRPCPort {
+		errs = append(errs, fmt.Errorf("ADMIN_PORT: %q is already used by SERVER_PORT or GRPC_PORT", c.AdminPort))
+	}
+	if c.GRPCPort != "" {
+		if port, err := strconv.Atoi(c.GRPCPort); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("GRPC_PORT: %q is not a valid port", c.GRPCPort))
+		}
+	}
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"SERVER_READ_TIMEOUT", c.ReadTimeout},
+		{"SERVER_IDLE_TIMEOUT", c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"READINESS_TIMEOUT", c.ReadyTimeout},
+	} {
+		if timeout.value <= 0 {
+			errs = append(errs, fmt.Errorf("%s: must be greater than zero, got %v", timeout.key, timeout.value))
+		}
+	}
+	// Zero desativa o timeout de escrita, como em http.Server
+	if c.WriteTimeout < 0 {
+		errs = append(errs, fmt.Errorf("SERVER_WRITE_TIMEOUT: must not be negative, got %v", c.WriteTimeout))
+	}
+	if c.ReloadInterval <= 0 {
+		errs = append(errs, fmt.Errorf("CONFIG_RELOAD_INTERVAL: must be greater than zero, got %v", c.ReloadInterval))
+	}
+	if c.Policies && c.PolicyCacheTTL <= 0 {
+		errs = append(errs, fmt.Errorf("POLICY_CACHE_TTL: must be greater than zero, got %v", c.PolicyCacheTTL))
+	}
+	if c.UsageReporting && c.UsageRetention <= 0 {
+		errs = append(errs, fmt.Errorf("USAGE_RETENTION: must be greater than zero, got %v", c.UsageRetention))
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("LOG_LEVEL: must be debug, info, warn or error, got %q", c.LogLevel))
+	}
+	if c.LogSampleRate < 0 || c.LogSampleRate > 1 {
+		errs = append(errs, fmt.Errorf("LOG_ALLOWED_SAMPLE_RATE: must be between 0 and 1, got %v", c.LogSampleRate))
+	}
+	switch c.TracingExporter {
+	case "none", "stdout", "otlp":
+	default:
+		errs = append(errs, fmt.Errorf("TRACING_EXPORTER: must be none, stdout or otlp, got %q", c.TracingExporter))
+	}
+	switch c.Strategy {
+	case "exact":
+	case "batched":
+		if c.SyncInterval <= 0 {
+			errs = append(errs, fmt.Errorf("LIMITER_SYNC_INTERVAL: must be greater than zero, got %v", c.SyncInterval))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("LIMITER_STRATEGY: must be exact or batched, got %q", c.Strategy))
+	}
+
+	names := make([]string, 0, len(c.Plans))
+	for name := range c.Plans {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p := c.Plans[name]
+		if p.Limit <= 0 {
+			errs = append(errs, fmt.Errorf("plans.%s.limit: must be greater than zero, got %d", name, p.Limit))
+		}
+		if p.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("plans.%s.duration: must be greater than zero, got %v", name, p.Duration))
+		}
+		if p.BlockTime < p.Duration {
+			errs = append(errs, fmt.Errorf("plans.%s.block_time: must not be shorter than duration (%v), got %v", name, p.Duration, p.BlockTime))
+		}
+		errs = append(errs, validateWindows("plans."+name+".windows", p.Duration, p.Windows)...)
+		errs = append(errs, validateQuota("plans."+name+".quota", p.Quota)...)
+		if p.Concurrency < 0 {
+			errs = append(errs, fmt.Errorf("plans.%s.concurrency: must not be negative, got %d", name, p.Concurrency))
+		}
+		if p.QueueMaxDelay < 0 {
+			errs = append(errs, fmt.Errorf("plans.%s.queue_max_delay: must not be negative, got %v", name, p.QueueMaxDelay))
+		}
+		if c.WriteTimeout > 0 && p.QueueMaxDelay >= c.WriteTimeout {
+			errs = append(errs, fmt.Errorf("plans.%s.queue_max_delay: must be shorter than SERVER_WRITE_TIMEOUT (%v), got %v", name, c.WriteTimeout, p.QueueMaxDelay))
+		}
+	}
+	errs = append(errs, validateWindows("IP_WINDOWS", c.IPDuration, c.IPWindows)...)
+	errs = append(errs, validateWindows("TOKEN_WINDOWS", c.IPDuration, c.TokenWindows)...)
+	errs = append(errs, validateQuota("IP_QUOTA", c.IPQuota)...)
+	errs = append(errs, validateQuota("TOKEN_QUOTA", c.TokenQuota)...)
+	if c.IPConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("IP_CONCURRENCY: must not be negative, got %d", c.IPConcurrency))
+	}
+	if c.TokenConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("TOKEN_CONCURRENCY: must not be negative, got %d", c.TokenConcurrency))
+	}
+	if c.ConcurrencyLease <= 0 {
+		errs = append(errs, fmt.Errorf("CONCURRENCY_LEASE: must be greater than zero, got %v", c.ConcurrencyLease))
+	}
+	if c.QueueMaxDelay < 0 {
+		errs = append(errs, fmt.Errorf("QUEUE_MAX_DELAY: must not be negative, got %v", c.QueueMaxDelay))
+	}
+	// A espera conta no prazo de escrita: uma fila mais longa que ele teria a
+	// conexão cortada antes de a resposta sair.
+	if c.WriteTimeout > 0 && c.QueueMaxDelay >= c.WriteTimeout {
+		errs = append(errs, fmt.Errorf("QUEUE_MAX_DELAY: must be shorter than SERVER_WRITE_TIMEOUT (%v), got %v", c.WriteTimeout, c.QueueMaxDelay))
+	}
+	if c.QueueSize <= 0 {
+		errs = append(errs, fmt.Errorf("QUEUE_SIZE: must be greater than zero, got %d", c.QueueSize))
+	}
+
+	tokens := make([]string, 0, len(c.Tokens))
+	for token := range c.Tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Strings(tokens)
+	for _, token := range tokens {
+		if _, ok := c.Plans[c.Tokens[token]]; !ok {
+			errs = append(errs, fmt.Errorf("tokens.%s: unknown plan %q", token, c.Tokens[token]))
+		}
+	}
+
+	for _, entry := range c.Allowlist {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("ALLOWLIST: %q is not a valid IP or CIDR", entry))
+		}
+	}
+	for _, entry := range c.Denylist {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("DENYLIST: %q is not a valid IP or CIDR", entry))
+		}
+	}
+	for _, entry := range c.TrustedProxies {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %q is not a valid IP or CIDR", entry))
+		}
+	}
+	for _, entry := range c.Skip.Networks {
+		if !validIPOrCIDR(entry) {
+			errs = append(errs, fmt.Errorf("SKIP_NETWORKS: %q is not a valid IP or CIDR", entry))
+		}
+	}
+	for _, path := range c.Skip.Paths {
+		if !strings.HasPrefix(path, "/") {
+			errs = append(errs, fmt.Errorf("SKIP_PATHS: %q must start with /", path))
+		}
+	}
+	if c.Skip.BypassSecret != "" {
+		if c.Skip.BypassHeader == "" {
+			errs = append(errs, fmt.Errorf("BYPASS_HEADER: must not be empty when BYPASS_SECRET is set"))
+		}
+		if c.Skip.BypassMaxAge <= 0 {
+			errs = append(errs, fmt.Errorf("BYPASS_MAX_AGE: must be greater than zero, got %v", c.Skip.BypassMaxAge))
+		}
+	}
+
+	for i, route := range c.Routes {
+		if !strings.HasPrefix(route.Path, "/") {
+			errs = append(errs, fmt.Errorf("routes[%d].path: must start with /, got %q", i, route.Path))
+		}
+		if route.Cost < 0 {
+			errs = append(errs, fmt.Errorf("routes[%d].cost: must not be negative, got %d", i, route.Cost))
+		}
+		if route.BodyBytesPerUnit < 0 {
+			errs = append(errs, fmt.Errorf("routes[%d].body_bytes_per_unit: must not be negative, got %d", i, route.BodyBytesPerUnit))
+		}
+		if route.ResponseBytesPerUnit < 0 {
+			errs = append(errs, fmt.Errorf("routes[%d].response_bytes_per_unit: must not be negative, got %d", i, route.ResponseBytesPerUnit))
+		}
+	}
+
+	prefixes := make([]string, 0, len(c.Upstreams))
+	for prefix := range c.Upstreams {
+		prefixes = append(prefixes, prefix)
 	}
+	sort.Strings(prefixes)
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			errs = append(errs, fmt.Errorf("upstreams.%s: path prefix must start with /", prefix))
+		}
+		target, err := url.Parse(c.Upstreams[prefix])
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			errs = append(errs, fmt.Errorf("upstreams.%s: %q is not an absolute http or https URL", prefix, c.Upstreams[prefix]))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateWindows exige durações distintas, já que cada janela é contada numa
+// chave com a duração como sufixo.
+func validateWindows(key string, primary time.Duration, windows []Window) []error {
+	var errs []error
+	seen := map[time.Duration]bool{primary: true}
+	for i, w := range windows {
+		if w.Limit <= 0 {
+			errs = append(errs, fmt.Errorf("%s[%d].limit: must be greater than zero, got %d", key, i, w.Limit))
+		}
+		if w.Duration <= 0 {
+			errs = append(errs, fmt.Errorf("%s[%d].duration: must be greater than zero, got %v", key, i, w.Duration))
+		} else if seen[w.Duration] {
+			errs = append(errs, fmt.Errorf("%s[%d].duration: %v is already used by another window", key, i, w.Duration))
+		}
+		seen[w.Duration] = true
+	}
+	return errs
+}
+
+func validateQuota(key string, q Quota) []error {
+	var errs []error
+	if q.Limit < 0 {
+		errs = append(errs, fmt.Errorf("%s.limit: must not be negative, got %d", key, q.Limit))
+	}
+	if q.Limit == 0 {
+		return errs
+	}
+	if q.Period != "daily" && q.Period != "monthly" {
+		errs = append(errs, fmt.Errorf("%s.period: must be daily or monthly, got %q", key, q.Period))
+	}
+	if _, err := time.LoadLocation(q.Timezone); err != nil {
+		errs = append(errs, fmt.Errorf("%s.timezone: %q is not a valid time zone", key, q.Timezone))
+	}
+	return errs
+}
+
+func validIPOrCIDR(entry string) bool {
+	if _, _, err := net.ParseCIDR(entry); err == nil {
+		return true
+	}
+	return net.ParseIP(entry) != nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -35,21 +510,110 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func getEnvAsInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intVal, err := strconv.Atoi(value); err == nil {
-			return intVal
+func getEnvAsInt(key string, defaultValue int, errs *[]error) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	intVal, err := strconv.Atoi(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a valid integer", key, value))
+		return defaultValue
+	}
+	return intVal
+}
+
+func getEnvAsBool(key string, defaultValue bool, errs *[]error) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	boolVal, err := strconv.ParseBool(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a valid boolean", key, value))
+		return defaultValue
+	}
+	return boolVal
+}
+
+func getEnvAsFloat(key string, defaultValue float64, errs *[]error) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	floatVal, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a valid number", key, value))
+		return defaultValue
+	}
+	return floatVal
+}
+
+func getEnvAsDuration(key string, defaultValue time.Duration, errs *[]error) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a valid duration", key, value))
+		return defaultValue
+	}
+	return duration
+}
+
+func getEnvAsList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
 		}
 	}
-	return defaultValue
+	return list
 }
 
-func getEnvAsDuration(key string, defaultValue string) time.Duration {
-	if value := os.Getenv(key); value != "" {
-		if duration, err := time.ParseDuration(value); err == nil {
-			return duration
+// getEnvAsWindows lê janelas no formato "500/1m,50000/24h".
+func getEnvAsWindows(key string, defaultValue []Window, errs *[]error) []Window {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	var windows []Window
+	for _, item := range getEnvAsList(key, nil) {
+		limit, period, ok := strings.Cut(item, "/")
+		n, err := strconv.Atoi(strings.TrimSpace(limit))
+		if !ok || err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %q is not a valid window, use <limit>/<duration>", key, item))
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(period))
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %q is not a valid window, use <limit>/<duration>", key, item))
+			continue
 		}
+		windows = append(windows, Window{Limit: n, Duration: d})
 	}
-	duration, _ := time.ParseDuration(defaultValue)
-	return duration
+	return windows
+}
+
+// getEnvAsQuota lê uma cota no formato "100000/monthly" ou "5000/daily",
+// mantendo o fuso já configurado.
+func getEnvAsQuota(key string, defaultValue Quota, errs *[]error) Quota {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	limit, period, ok := strings.Cut(value, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if !ok || err != nil {
+		*errs = append(*errs, fmt.Errorf("%s: %q is not a valid quota, use <limit>/daily or <limit>/monthly", key, value))
+		return defaultValue
+	}
+	defaultValue.Limit = n
+	defaultValue.Period = strings.TrimSpace(period)
+	return defaultValue
 }
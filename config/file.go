@@ -0,0 +1,276 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+type fileConfig struct {
+	RedisAddr    *string             `json:"redis_addr" yaml:"redis_addr" toml:"redis_addr"`
+	ServerPort   *string             `json:"server_port" yaml:"server_port" toml:"server_port"`
+	GRPCPort     *string             `json:"grpc_port" yaml:"grpc_port" toml:"grpc_port"`
+	AdminPort    *string             `json:"admin_port" yaml:"admin_port" toml:"admin_port"`
+	Strategy     *string             `json:"strategy" yaml:"strategy" toml:"strategy"`
+	SyncInterval *duration           `json:"sync_interval" yaml:"sync_interval" toml:"sync_interval"`
+	Policies     *bool               `json:"dynamic_policies" yaml:"dynamic_policies" toml:"dynamic_policies"`
+	PolicyTTL    *duration           `json:"policy_cache_ttl" yaml:"policy_cache_ttl" toml:"policy_cache_ttl"`
+	IP           fileRule            `json:"ip" yaml:"ip" toml:"ip"`
+	Token        fileRule            `json:"token" yaml:"token" toml:"token"`
+	Plans        map[string]filePlan `json:"plans" yaml:"plans" toml:"plans"`
+	Tokens       map[string]string   `json:"tokens" yaml:"tokens" toml:"tokens"`
+	Allowlist    []string            `json:"allowlist" yaml:"allowlist" toml:"allowlist"`
+	Denylist     []string            `json:"denylist" yaml:"denylist" toml:"denylist"`
+	Proxies      []string            `json:"trusted_proxies" yaml:"trusted_proxies" toml:"trusted_proxies"`
+	Upstreams    map[string]string   `json:"upstreams" yaml:"upstreams" toml:"upstreams"`
+	Routes       []fileRoute         `json:"routes" yaml:"routes" toml:"routes"`
+	AdminToken   *string             `json:"admin_token" yaml:"admin_token" toml:"admin_token"`
+	Usage        *bool               `json:"usage_reporting" yaml:"usage_reporting" toml:"usage_reporting"`
+	UsageTTL     *duration           `json:"usage_retention" yaml:"usage_retention" toml:"usage_retention"`
+	UsageFold    *bool               `json:"usage_fold_unknown_tokens" yaml:"usage_fold_unknown_tokens" toml:"usage_fold_unknown_tokens"`
+	LogLevel     *string             `json:"log_level" yaml:"log_level" toml:"log_level"`
+	LogSample    *float64            `json:"log_allowed_sample_rate" yaml:"log_allowed_sample_rate" toml:"log_allowed_sample_rate"`
+	LogSecret    *string             `json:"log_key_hash_secret" yaml:"log_key_hash_secret" toml:"log_key_hash_secret"`
+	Tracing      *string             `json:"tracing_exporter" yaml:"tracing_exporter" toml:"tracing_exporter"`
+	Template     *string             `json:"limited_html_template" yaml:"limited_html_template" toml:"limited_html_template"`
+	Skip         fileSkip            `json:"skip" yaml:"skip" toml:"skip"`
+	Lease        *duration           `json:"concurrency_lease" yaml:"concurrency_lease" toml:"concurrency_lease"`
+	Queue        fileQueue           `json:"queue" yaml:"queue" toml:"queue"`
+}
+
+type fileSkip struct {
+	Paths     []string    `json:"paths" yaml:"paths" toml:"paths"`
+	Methods   []string    `json:"methods" yaml:"methods" toml:"methods"`
+	Preflight *bool       `json:"preflight" yaml:"preflight" toml:"preflight"`
+	Networks  []string    `json:"networks" yaml:"networks" toml:"networks"`
+	Bypass    *fileBypass `json:"bypass" yaml:"bypass" toml:"bypass"`
+}
+
+type fileBypass struct {
+	Secret *string   `json:"secret" yaml:"secret" toml:"secret"`
+	Header *string   `json:"header" yaml:"header" toml:"header"`
+	MaxAge *duration `json:"max_age" yaml:"max_age" toml:"max_age"`
+}
+
+type fileQueue struct {
+	MaxDelay *duration `json:"max_delay" yaml:"max_delay" toml:"max_delay"`
+	Size     *int      `json:"size" yaml:"size" toml:"size"`
+}
+
+type fileRoute struct {
+	Method               string `json:"method" yaml:"method" toml:"method"`
+	Path                 string `json:"path" yaml:"path" toml:"path"`
+	Cost                 int    `json:"cost" yaml:"cost" toml:"cost"`
+	QueryParam           string `json:"query_param" yaml:"query_param" toml:"query_param"`
+	BodyBytesPerUnit     int64  `json:"body_bytes_per_unit" yaml:"body_bytes_per_unit" toml:"body_bytes_per_unit"`
+	OnlyFailures         bool   `json:"only_failures" yaml:"only_failures" toml:"only_failures"`
+	ResponseBytesPerUnit int64  `json:"response_bytes_per_unit" yaml:"response_bytes_per_unit" toml:"response_bytes_per_unit"`
+}
+
+type fileRule struct {
+	Limit       *int         `json:"limit" yaml:"limit" toml:"limit"`
+	Duration    *duration    `json:"duration" yaml:"duration" toml:"duration"`
+	BlockTime   *duration    `json:"block_time" yaml:"block_time" toml:"block_time"`
+	Windows     []fileWindow `json:"windows" yaml:"windows" toml:"windows"`
+	Quota       *fileQuota   `json:"quota" yaml:"quota" toml:"quota"`
+	Concurrency *int         `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+}
+
+type filePlan struct {
+	Limit       int          `json:"limit" yaml:"limit" toml:"limit"`
+	Duration    duration     `json:"duration" yaml:"duration" toml:"duration"`
+	BlockTime   duration     `json:"block_time" yaml:"block_time" toml:"block_time"`
+	Windows     []fileWindow `json:"windows" yaml:"windows" toml:"windows"`
+	Quota       fileQuota    `json:"quota" yaml:"quota" toml:"quota"`
+	Concurrency int          `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+	QueueDelay  duration     `json:"queue_max_delay" yaml:"queue_max_delay" toml:"queue_max_delay"`
+}
+
+type fileQuota struct {
+	Limit    int    `json:"limit" yaml:"limit" toml:"limit"`
+	Period   string `json:"period" yaml:"period" toml:"period"`
+	Timezone string `json:"timezone" yaml:"timezone" toml:"timezone"`
+}
+
+type fileWindow struct {
+	Limit    int      `json:"limit" yaml:"limit" toml:"limit"`
+	Duration duration `json:"duration" yaml:"duration" toml:"duration"`
+}
+
+type duration time.Duration
+
+func (d *duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("%q is not a valid duration", text)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("RATE_LIMIT_CONFIG: %w", err)
+	}
+
+	// Chaves desconhecidas são erro: um erro de digitação não pode deixar a
+	// regra de fora em silêncio.
+	var file fileConfig
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&file); errors.Is(err, io.EOF) {
+			err = nil
+		}
+	case ".toml":
+		var meta toml.MetaData
+		meta, err = toml.Decode(string(data), &file)
+		if undecoded := meta.Undecoded(); err == nil && len(undecoded) > 0 {
+			err = fmt.Errorf("unknown key %q", undecoded[0].String())
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&file)
+	default:
+		return fmt.Errorf("RATE_LIMIT_CONFIG: unsupported file extension %q, use .yaml, .yml, .toml or .json", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("RATE_LIMIT_CONFIG: parsing %s: %w", path, err)
+	}
+	// Os limites de token usam a janela de ip.duration; uma duração própria
+	// só existe nos planos.
+	if file.Token.Duration != nil {
+		return fmt.Errorf("token.duration: token limits share ip.duration, use a plan or token.windows for a different window")
+	}
+
+	file.apply(cfg)
+	return nil
+}
+
+func (f *fileConfig) apply(cfg *Config) {
+	setIfPresent(&cfg.RedisAddr, f.RedisAddr)
+	setIfPresent(&cfg.ServerPort, f.ServerPort)
+	setIfPresent(&cfg.GRPCPort, f.GRPCPort)
+	setIfPresent(&cfg.AdminPort, f.AdminPort)
+	setIfPresent(&cfg.Strategy, f.Strategy)
+	setIfPresent(&cfg.Policies, f.Policies)
+	setIfPresent(&cfg.AdminToken, f.AdminToken)
+	setIfPresent(&cfg.UsageReporting, f.Usage)
+	setIfPresent(&cfg.UsageFoldUnknownTokens, f.UsageFold)
+	setIfPresent(&cfg.LogLevel, f.LogLevel)
+	setIfPresent(&cfg.LogSampleRate, f.LogSample)
+	setIfPresent(&cfg.LogKeyHashSecret, f.LogSecret)
+	setIfPresent(&cfg.TracingExporter, f.Tracing)
+	setIfPresent(&cfg.LimitedTemplate, f.Template)
+	setIfPresent(&cfg.IPLimit, f.IP.Limit)
+	setIfPresent(&cfg.TokenLimit, f.Token.Limit)
+	setIfPresent(&cfg.IPConcurrency, f.IP.Concurrency)
+	setIfPresent(&cfg.TokenConcurrency, f.Token.Concurrency)
+	setDurationIfPresent(&cfg.SyncInterval, f.SyncInterval)
+	setDurationIfPresent(&cfg.PolicyCacheTTL, f.PolicyTTL)
+	setDurationIfPresent(&cfg.UsageRetention, f.UsageTTL)
+	setDurationIfPresent(&cfg.IPDuration, f.IP.Duration)
+	setDurationIfPresent(&cfg.IPBlockTime, f.IP.BlockTime)
+	setDurationIfPresent(&cfg.TokenBlockTime, f.Token.BlockTime)
+	setDurationIfPresent(&cfg.ConcurrencyLease, f.Lease)
+	setDurationIfPresent(&cfg.QueueMaxDelay, f.Queue.MaxDelay)
+	setIfPresent(&cfg.QueueSize, f.Queue.Size)
+
+	if len(f.Plans) > 0 {
+		cfg.Plans = make(map[string]Plan, len(f.Plans))
+		for name, p := range f.Plans {
+			cfg.Plans[name] = Plan{
+				Limit:         p.Limit,
+				Duration:      time.Duration(p.Duration),
+				BlockTime:     time.Duration(p.BlockTime),
+				Windows:       windows(p.Windows),
+				Quota:         Quota(p.Quota),
+				Concurrency:   p.Concurrency,
+				QueueMaxDelay: time.Duration(p.QueueDelay),
+			}
+		}
+	}
+	if f.IP.Windows != nil {
+		cfg.IPWindows = windows(f.IP.Windows)
+	}
+	if f.Token.Windows != nil {
+		cfg.TokenWindows = windows(f.Token.Windows)
+	}
+	if f.IP.Quota != nil {
+		cfg.IPQuota = Quota(*f.IP.Quota)
+	}
+	if f.Token.Quota != nil {
+		cfg.TokenQuota = Quota(*f.Token.Quota)
+	}
+	if len(f.Tokens) > 0 {
+		cfg.Tokens = f.Tokens
+	}
+	if len(f.Routes) > 0 {
+		cfg.Routes = make([]Route, len(f.Routes))
+		for i, r := range f.Routes {
+			cfg.Routes[i] = Route(r)
+		}
+	}
+	if len(f.Upstreams) > 0 {
+		cfg.Upstreams = f.Upstreams
+	}
+	if f.Allowlist != nil {
+		cfg.Allowlist = f.Allowlist
+	}
+	if f.Denylist != nil {
+		cfg.Denylist = f.Denylist
+	}
+	if f.Proxies != nil {
+		cfg.TrustedProxies = f.Proxies
+	}
+	if f.Skip.Paths != nil {
+		cfg.Skip.Paths = f.Skip.Paths
+	}
+	if f.Skip.Methods != nil {
+		cfg.Skip.Methods = f.Skip.Methods
+	}
+	if f.Skip.Networks != nil {
+		cfg.Skip.Networks = f.Skip.Networks
+	}
+	setIfPresent(&cfg.Skip.Preflight, f.Skip.Preflight)
+	if f.Skip.Bypass != nil {
+		setIfPresent(&cfg.Skip.BypassSecret, f.Skip.Bypass.Secret)
+		setIfPresent(&cfg.Skip.BypassHeader, f.Skip.Bypass.Header)
+		setDurationIfPresent(&cfg.Skip.BypassMaxAge, f.Skip.Bypass.MaxAge)
+	}
+}
+
+func windows(file []fileWindow) []Window {
+	if len(file) == 0 {
+		return nil
+	}
+	out := make([]Window, len(file))
+	for i, w := range file {
+		out[i] = Window{Limit: w.Limit, Duration: time.Duration(w.Duration)}
+	}
+	return out
+}
+
+func setIfPresent[T any](dst *T, src *T) {
+	if src != nil {
+		*dst = *src
+	}
+}
+
+func setDurationIfPresent(dst *time.Duration, src *duration) {
+	if src != nil {
+		*dst = time.Duration(*src)
+	}
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// Watch chama reload ao receber SIGHUP e sempre que o arquivo em path mudar
+// (verificado a cada interval), até que ctx seja cancelado.
+func Watch(ctx context.Context, path string, interval time.Duration, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var ticks <-chan time.Time
+	if path != "" {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	last := fileVersion(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			last = fileVersion(path)
+			reload()
+		case <-ticks:
+			if current := fileVersion(path); current != last {
+				last = current
+				reload()
+			}
+		}
+	}
+}
+
+type version struct {
+	modTime time.Time
+	size    int64
+}
+
+func fileVersion(path string) version {
+	if path == "" {
+		return version{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return version{}
+	}
+	return version{modTime: info.ModTime(), size: info.Size()}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"go-expert-rater-limit/config"
+	"go-expert-rater-limit/storage"
+	"go-expert-rater-limit/usage"
+)
+
+// exportUsage implementa "export-usage -from AAAA-MM-DD [-to AAAA-MM-DD]
+// [-format csv|jsonl] [-o arquivo]", lendo os agregados direto do Redis.
+func exportUsage(cfg *config.Config, args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export-usage", flag.ContinueOnError)
+	fromFlag := flags.String("from", time.Now().UTC().Format("2006-01-02"), "first day (YYYY-MM-DD, UTC)")
+	toFlag := flags.String("to", "", "last day, inclusive (defaults to -from)")
+	format := flags.String("format", usage.FormatCSV, "csv or jsonl")
+	output := flags.String("o", "", "output file (defaults to stdout)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	from, err := usage.ParseDate(*fromFlag)
+	if err != nil {
+		return fmt.Errorf("-from: %q is not a valid date", *fromFlag)
+	}
+	to := from
+	if *toFlag != "" {
+		if to, err = usage.ParseDate(*toFlag); err != nil {
+			return fmt.Errorf("-to: %q is not a valid date", *toFlag)
+		}
+	}
+
+	redisClient := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr})
+	defer redisClient.Close()
+
+	records, err := usage.NewRecorder(storage.NewRedisStorage(redisClient), cfg.UsageRetention).Report(from, to)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		return usage.Write(stdout, *format, records)
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := usage.Write(file, *format, records); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
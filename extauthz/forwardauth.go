@@ -0,0 +1,53 @@
+package extauthz
+
+import (
+	"net/http"
+	"net/url"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// NewForwardAuthHandler atende ao ForwardAuth do Traefik e ao auth_request do
+// nginx. A requisição recebida é a verificação em si; método, URI e IP do
+// cliente originais vêm dos headers X-Forwarded-* / X-Original-* e a decisão
+// é a mesma do middleware: 200 permite, 429 (ou 403) rejeita.
+func NewForwardAuthHandler(m *middleware.RateLimiterMiddleware) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision := m.Decide(originalRequest(r))
+		middleware.SetRateLimitHeaders(w.Header(), decision.Result)
+		w.WriteHeader(decision.Status)
+	})
+}
+
+func originalRequest(r *http.Request) *http.Request {
+	original := r.Clone(r.Context())
+
+	if method := firstHeader(r, "X-Forwarded-Method", "X-Original-Method"); method != "" {
+		original.Method = method
+	}
+	if uri := firstHeader(r, "X-Forwarded-Uri", "X-Original-Uri", "X-Original-URL"); uri != "" {
+		if u, err := url.Parse(uri); err == nil {
+			original.URL = u
+			original.RequestURI = uri
+		}
+	}
+	if host := firstHeader(r, "X-Forwarded-Host", "X-Original-Host"); host != "" {
+		original.Host = host
+	}
+	// Como o X-Real-IP, o X-Original-Remote-Addr só vale quando a verificação
+	// vem de um proxy confiável, se houver middleware.Rules.TrustedProxies.
+	if remoteAddr := r.Header.Get("X-Original-Remote-Addr"); remoteAddr != "" && r.Header.Get("X-Forwarded-For") == "" && r.Header.Get("X-Real-IP") == "" {
+		original.Header.Set("X-Real-IP", remoteAddr)
+	}
+
+	return original
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,135 @@
+package extauthz
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// RateLimitService implementa o Rate Limit Service (RLS) v3 do Envoy sobre as
+// mesmas regras do middleware. Cada descriptor vira uma chave:
+//
+//   - entrada "api_key" -> "token:<valor>" (limites de token e planos)
+//   - entrada "remote_address" -> "ip:<valor>" (limites de IP)
+//   - demais -> "descriptor:<domain>:<chave>=<valor>,..." (limites de IP,
+//     ou a política dinâmica cadastrada para a chave)
+type RateLimitService struct {
+	rlsv3.UnimplementedRateLimitServiceServer
+	middleware *middleware.RateLimiterMiddleware
+}
+
+func NewRateLimitService(m *middleware.RateLimiterMiddleware) *RateLimitService {
+	return &RateLimitService{middleware: m}
+}
+
+func (s *RateLimitService) ShouldRateLimit(ctx context.Context, req *rlsv3.RateLimitRequest) (*rlsv3.RateLimitResponse, error) {
+	response := &rlsv3.RateLimitResponse{OverallCode: rlsv3.RateLimitResponse_OK}
+
+	var mostRestrictive *middleware.Decision
+	for _, descriptor := range req.GetDescriptors() {
+		hits := req.GetHitsAddend()
+		if descriptor.GetHitsAddend() != nil {
+			hits = uint32(descriptor.GetHitsAddend().GetValue())
+		}
+		if hits == 0 {
+			hits = 1
+		}
+
+		key := descriptorKey(req.GetDomain(), descriptor)
+		decision := s.middleware.CheckKeyNContext(ctx, key, int(hits))
+
+		status := &rlsv3.RateLimitResponse_DescriptorStatus{
+			Code: rlsv3.RateLimitResponse_OK,
+			CurrentLimit: &rlsv3.RateLimitResponse_RateLimit{
+				Name:            key,
+				RequestsPerUnit: uint32(decision.Result.Limit),
+				Unit:            unitFor(decision.Result.Duration),
+			},
+			LimitRemaining: uint32(max(decision.Result.Remaining, 0)),
+		}
+		// Cota esgotada também é OVER_LIMIT: o RLS não tem um código próprio
+		if decision.Status != http.StatusOK {
+			status.Code = rlsv3.RateLimitResponse_OVER_LIMIT
+			status.DurationUntilReset = durationpb.New(decision.RetryAfter())
+			response.OverallCode = rlsv3.RateLimitResponse_OVER_LIMIT
+		}
+		response.Statuses = append(response.Statuses, status)
+
+		if mostRestrictive == nil || moreRestrictive(decision, *mostRestrictive) {
+			mostRestrictive = &decision
+		}
+	}
+
+	if mostRestrictive != nil {
+		response.ResponseHeadersToAdd = rateLimitHeaders(*mostRestrictive)
+	}
+	return response, nil
+}
+
+func descriptorKey(domain string, descriptor *ratelimitv3.RateLimitDescriptor) string {
+	entries := descriptor.GetEntries()
+	if len(entries) == 1 {
+		switch strings.ToLower(entries[0].GetKey()) {
+		case "api_key":
+			return "token:" + entries[0].GetValue()
+		case "remote_address":
+			return "ip:" + entries[0].GetValue()
+		}
+	}
+
+	parts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		parts = append(parts, entry.GetKey()+"="+entry.GetValue())
+	}
+	return "descriptor:" + domain + ":" + strings.Join(parts, ",")
+}
+
+func unitFor(d time.Duration) rlsv3.RateLimitResponse_RateLimit_Unit {
+	switch d {
+	case time.Second:
+		return rlsv3.RateLimitResponse_RateLimit_SECOND
+	case time.Minute:
+		return rlsv3.RateLimitResponse_RateLimit_MINUTE
+	case time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_HOUR
+	case 24 * time.Hour:
+		return rlsv3.RateLimitResponse_RateLimit_DAY
+	}
+	return rlsv3.RateLimitResponse_RateLimit_UNKNOWN
+}
+
+func moreRestrictive(a, b middleware.Decision) bool {
+	aRejected, bRejected := a.Status != http.StatusOK, b.Status != http.StatusOK
+	if aRejected != bRejected {
+		return aRejected
+	}
+	if aRejected {
+		return a.RetryAfter() > b.RetryAfter()
+	}
+	return a.Result.Remaining < b.Result.Remaining
+}
+
+func rateLimitHeaders(decision middleware.Decision) []*corev3.HeaderValue {
+	result := decision.Result
+	headers := []*corev3.HeaderValue{
+		{Key: "X-RateLimit-Limit", Value: strconv.Itoa(result.Limit)},
+		{Key: "X-RateLimit-Remaining", Value: strconv.Itoa(max(result.Remaining, 0))},
+	}
+	if retryAfter := decision.RetryAfter(); decision.Status != http.StatusOK && retryAfter > 0 {
+		headers = append(headers, &corev3.HeaderValue{
+			Key:   "Retry-After",
+			Value: strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))),
+		})
+	}
+	return headers
+}
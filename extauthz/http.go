@@ -0,0 +1,25 @@
+package extauthz
+
+import (
+	"net/http"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// PathPrefix é onde NewHTTPHandler é montado, e o path_prefix a configurar
+// no filtro ext_authz do Envoy.
+const PathPrefix = "/ext_authz"
+
+// NewHTTPHandler responde às verificações do filtro ext_authz HTTP do Envoy:
+// o Envoy repassa a requisição original com PathPrefix na frente do caminho e
+// deixa passar somente quando a resposta é 200. O prefixo é removido antes da
+// decisão, para que custos de rota e SkipPaths vejam o caminho original. Os
+// headers de rate limit podem ser repassados ao cliente via
+// allowed_client_headers.
+func NewHTTPHandler(m *middleware.RateLimiterMiddleware) http.Handler {
+	return http.StripPrefix(PathPrefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		decision := m.Decide(r)
+		middleware.SetRateLimitHeaders(w.Header(), decision.Result)
+		w.WriteHeader(decision.Status)
+	}))
+}
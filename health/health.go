@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+// Check verifica uma dependência; nil significa que ela está pronta.
+type Check func(ctx context.Context) error
+
+// StorageCheck pinga o storage quando ele implementa storage.Pinger. Storages
+// sem Ping, como os em memória, são considerados sempre prontos.
+func StorageCheck(s storage.Storage) Check {
+	return func(ctx context.Context) error {
+		if pinger, ok := s.(storage.Pinger); ok {
+			return pinger.Ping(ctx)
+		}
+		return nil
+	}
+}
+
+// Checker responde /healthz e /readyz. A instância só fica pronta depois de
+// SetReady(true), chamado quando a configuração foi carregada e o servidor
+// montado, e volta a não pronta no desligamento para que o balanceador pare de
+// mandar tráfego enquanto as conexões são drenadas.
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+	ready   atomic.Bool
+}
+
+// NewChecker cria um Checker em que cada verificação tem até timeout para
+// responder.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add registra uma verificação de prontidão. Deve ser chamado antes de servir
+// requisições.
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+func (c *Checker) SetReady(ready bool) {
+	c.ready.Store(ready)
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Liveness responde 200 enquanto o processo consegue atender requisições, sem
+// consultar dependências: uma queda do Redis não deve reiniciar o processo.
+func (c *Checker) Liveness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, http.StatusOK, report{Status: "ok"})
+	})
+}
+
+// Readiness roda todas as verificações em paralelo e responde 200 se todas
+// passaram dentro do timeout ou 503 com o erro de cada uma que falhou.
+func (c *Checker) Readiness() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result := report{Status: "ok", Checks: make(map[string]string, len(c.names)+1)}
+		if c.ready.Load() {
+			result.Checks["config"] = "ok"
+		} else {
+			result.Checks["config"] = "not loaded"
+			result.Status = "unavailable"
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+		defer cancel()
+
+		type outcome struct {
+			name string
+			err  error
+		}
+		outcomes := make(chan outcome, len(c.names))
+		for _, name := range c.names {
+			result.Checks[name] = context.DeadlineExceeded.Error()
+			go func() {
+				outcomes <- outcome{name: name, err: c.checks[name](ctx)}
+			}()
+		}
+		for pending := len(c.names); pending > 0; pending-- {
+			select {
+			case o := <-outcomes:
+				if o.err == nil {
+					result.Checks[o.name] = "ok"
+				} else {
+					result.Checks[o.name] = o.err.Error()
+				}
+			case <-ctx.Done():
+				pending = 0
+			}
+		}
+
+		status := http.StatusOK
+		for _, check := range result.Checks {
+			if check != "ok" {
+				result.Status = "unavailable"
+				status = http.StatusServiceUnavailable
+			}
+		}
+		writeReport(w, status, result)
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, result report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Error writing health report: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package interceptor
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"go-expert-rater-limit/middleware"
+)
+
+// UnaryServerInterceptor aplica as regras do middleware HTTP às chamadas
+// unárias. A chave é o metadata "api-key" (limites de token) ou, na falta
+// dele, o endereço do peer (limites de IP).
+func UnaryServerInterceptor(m *middleware.RateLimiterMiddleware) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		decision := m.CheckKeyContext(ctx, keyFromContext(ctx))
+		if err := checkDecision(decision); err != nil {
+			_ = grpc.SetTrailer(ctx, rateLimitMetadata(decision))
+			return nil, err
+		}
+		_ = grpc.SetHeader(ctx, rateLimitMetadata(decision))
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor consome uma unidade do limite na abertura de cada
+// stream; as mensagens dentro do stream não são contadas.
+func StreamServerInterceptor(m *middleware.RateLimiterMiddleware) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		decision := m.CheckKeyContext(ss.Context(), keyFromContext(ss.Context()))
+		if err := checkDecision(decision); err != nil {
+			ss.SetTrailer(rateLimitMetadata(decision))
+			return err
+		}
+		_ = ss.SetHeader(rateLimitMetadata(decision))
+		return handler(srv, ss)
+	}
+}
+
+func keyFromContext(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, name := range []string{"api-key", "api_key"} {
+			if values := md.Get(name); len(values) > 0 && values[0] != "" {
+				return "token:" + values[0]
+			}
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		addr := p.Addr.String()
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			return "ip:" + host
+		}
+		return "ip:" + addr
+	}
+	return "ip:unknown"
+}
+
+// checkDecision traduz a rejeição para gRPC: ResourceExhausted para o limite
+// de requisições e PermissionDenied para a cota esgotada (ou a denylist),
+// como o 429 e o 403 do middleware HTTP.
+func checkDecision(decision middleware.Decision) error {
+	if decision.Status == http.StatusOK {
+		return nil
+	}
+
+	st := status.New(codes.ResourceExhausted, "you have reached the maximum number of requests or actions allowed within a certain time frame")
+	if decision.Status == http.StatusForbidden {
+		st = status.New(codes.PermissionDenied, "you have used your request quota")
+	}
+	if retryAfter := decision.RetryAfter(); retryAfter > 0 {
+		if withDetails, err := st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter),
+		}); err == nil {
+			st = withDetails
+		}
+	}
+	return st.Err()
+}
+
+func rateLimitMetadata(decision middleware.Decision) metadata.MD {
+	result := decision.Result
+	md := metadata.Pairs(
+		"x-ratelimit-limit", strconv.Itoa(result.Limit),
+		"x-ratelimit-remaining", strconv.Itoa(max(result.Remaining, 0)),
+	)
+	if retryAfter := decision.RetryAfter(); decision.Status != http.StatusOK && retryAfter > 0 {
+		md.Set("retry-after", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	return md
+}
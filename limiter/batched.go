@@ -0,0 +1,328 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+type BatchedRateLimiter struct {
+	storage      storage.Storage
+	syncInterval time.Duration
+
+	mu       sync.Mutex
+	counters map[string]*localCounter
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type localCounter struct {
+	global       int
+	inflight     int
+	pending      int
+	duration     time.Duration
+	blockTime    time.Duration
+	blocked      bool
+	blockPending bool
+	touched      bool
+}
+
+// NewBatchedRateLimiter conta as requisições localmente e sincroniza os deltas
+// com o storage a cada syncInterval. Entre duas sincronizações cada instância
+// só enxerga o próprio tráfego, então com N instâncias o limite pode ser
+// excedido em até (N-1) * limit requisições por intervalo.
+func NewBatchedRateLimiter(storage storage.Storage, syncInterval time.Duration) *BatchedRateLimiter {
+	b := &BatchedRateLimiter{
+		storage:      storage,
+		syncInterval: syncInterval,
+		counters:     make(map[string]*localCounter),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+func (b *BatchedRateLimiter) IsAllowed(key string, limit int, duration time.Duration, blockTime time.Duration) bool {
+	return b.Check(key, limit, duration, blockTime).Allowed
+}
+
+func (b *BatchedRateLimiter) Check(key string, limit int, duration time.Duration, blockTime time.Duration) Result {
+	return b.CheckN(key, 1, limit, duration, blockTime)
+}
+
+func (b *BatchedRateLimiter) CheckN(key string, n int, limit int, duration time.Duration, blockTime time.Duration) Result {
+	result := Result{Limit: limit, Duration: duration}
+
+	c, err := b.counter(key, duration, blockTime)
+	if err != nil {
+		result.RetryAfter = duration
+		return result
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c.touched = true
+	c.duration = duration
+	c.blockTime = blockTime
+
+	if c.blocked {
+		result.RetryAfter = blockTime
+		return result
+	}
+
+	current := c.global + c.inflight + c.pending
+	result.Remaining = max(limit-current, 0)
+	if current >= limit {
+		c.blocked = true
+		c.blockPending = true
+		result.RetryAfter = blockTime
+		return result
+	}
+	if current+n > limit {
+		result.RetryAfter = duration
+		return result
+	}
+
+	c.pending += n
+	result.Allowed = true
+	result.Remaining = limit - current - n
+	return result
+}
+
+// Peek consulta a visão local da chave, sem consumir unidades nem bloqueá-la.
+// Uma chave ainda sem contador local é lida do storage.
+func (b *BatchedRateLimiter) Peek(key string, n int, limit int, duration time.Duration) Result {
+	result := Result{Limit: limit, Duration: duration}
+
+	b.mu.Lock()
+	c, ok := b.counters[key]
+	var counter localCounter
+	if ok {
+		counter = *c
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		seeded, err := b.seed(key, duration, duration)
+		if err != nil {
+			result.RetryAfter = duration
+			return result
+		}
+		counter = *seeded
+	}
+
+	if counter.blocked {
+		result.RetryAfter = counter.blockTime
+		return result
+	}
+	current := counter.global + counter.inflight + counter.pending
+	result.Remaining = max(limit-current, 0)
+	if current+n > limit {
+		result.RetryAfter = duration
+		return result
+	}
+	result.Allowed = true
+	return result
+}
+
+// Take consome n unidades da visão local se elas couberem, sem bloquear a
+// chave.
+func (b *BatchedRateLimiter) Take(key string, n int, limit int, duration time.Duration) Result {
+	result := Result{Limit: limit, Duration: duration}
+
+	c, err := b.counter(key, duration, duration)
+	if err != nil {
+		result.RetryAfter = duration
+		return result
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	c.touched = true
+	c.duration = duration
+	if c.blocked {
+		result.RetryAfter = c.blockTime
+		return result
+	}
+	current := c.global + c.inflight + c.pending
+	result.Remaining = max(limit-current, 0)
+	if current+n > limit {
+		result.RetryAfter = duration
+		return result
+	}
+	c.pending += n
+	result.Allowed = true
+	result.Remaining = limit - current - n
+	return result
+}
+
+func (b *BatchedRateLimiter) Block(key string, duration time.Duration) error {
+	b.mu.Lock()
+	if c, ok := b.counters[key]; ok {
+		c.blocked = true
+		c.blockPending = false
+	}
+	b.mu.Unlock()
+
+	return b.storage.Block(key, duration)
+}
+
+// Refund devolve n unidades localmente; a devolução chega ao storage no
+// próximo Flush.
+func (b *BatchedRateLimiter) Refund(key string, n int, duration time.Duration) error {
+	b.mu.Lock()
+	c, ok := b.counters[key]
+	if ok {
+		c.pending -= n
+		c.touched = true
+	}
+	b.mu.Unlock()
+
+	if !ok {
+		return refund(b.storage, key, n, duration)
+	}
+	return nil
+}
+
+// Flush envia imediatamente os deltas pendentes ao storage e atualiza a visão
+// local com os contadores globais.
+func (b *BatchedRateLimiter) Flush() {
+	type snapshot struct {
+		key          string
+		delta        int
+		duration     time.Duration
+		blockTime    time.Duration
+		blockPending bool
+	}
+
+	b.mu.Lock()
+	snapshots := make([]snapshot, 0, len(b.counters))
+	for key, c := range b.counters {
+		if !c.touched && c.pending == 0 && !c.blockPending && !c.blocked && c.global == 0 {
+			delete(b.counters, key)
+			continue
+		}
+		snapshots = append(snapshots, snapshot{
+			key:          key,
+			delta:        c.pending,
+			duration:     c.duration,
+			blockTime:    c.blockTime,
+			blockPending: c.blockPending,
+		})
+		c.inflight += c.pending
+		c.pending = 0
+		c.blockPending = false
+		c.touched = false
+	}
+	b.mu.Unlock()
+
+	for _, s := range snapshots {
+		switch {
+		case s.delta > 0:
+			_ = b.add(s.key, s.delta, s.duration)
+		case s.delta < 0:
+			_ = refund(b.storage, s.key, -s.delta, s.duration)
+		}
+		if s.blockPending {
+			_ = b.storage.Block(s.key, s.blockTime)
+		}
+
+		current, err := b.storage.Get(s.key)
+		blocked := b.storage.IsBlocked(s.key)
+
+		b.mu.Lock()
+		if c, ok := b.counters[s.key]; ok {
+			c.inflight -= s.delta
+			if err == nil {
+				c.global = current
+			}
+			c.blocked = blocked || c.blockPending
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Close interrompe a sincronização periódica após um último Flush.
+func (b *BatchedRateLimiter) Close() {
+	b.once.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+}
+
+func (b *BatchedRateLimiter) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.Flush()
+		case <-b.stop:
+			b.Flush()
+			return
+		}
+	}
+}
+
+// counter devolve o contador local da chave, criando-o a partir do storage
+// na primeira vez.
+func (b *BatchedRateLimiter) counter(key string, duration, blockTime time.Duration) (*localCounter, error) {
+	b.mu.Lock()
+	c, ok := b.counters[key]
+	b.mu.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	seeded, err := b.seed(key, duration, blockTime)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c, ok = b.counters[key]; !ok {
+		c = seeded
+		b.counters[key] = c
+	}
+	return c, nil
+}
+
+func (b *BatchedRateLimiter) seed(key string, duration, blockTime time.Duration) (*localCounter, error) {
+	current, err := b.storage.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &localCounter{
+		global:    current,
+		duration:  duration,
+		blockTime: blockTime,
+		blocked:   b.storage.IsBlocked(key),
+	}, nil
+}
+
+// add soma delta ao contador global. Todas as instâncias sincronizam no mesmo
+// intervalo, então com storage.Counter o incremento é atômico; sem ele, dois
+// Flush simultâneos podem ler o mesmo valor e um incremento se perde.
+func (b *BatchedRateLimiter) add(key string, delta int, duration time.Duration) error {
+	if counter, ok := b.storage.(storage.Counter); ok {
+		_, err := counter.IncrWithExpiration(key, delta, duration)
+		return err
+	}
+	current, err := b.storage.Get(key)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return b.storage.Set(key, delta, duration)
+	}
+	return incrBy(b.storage, key, delta)
+}
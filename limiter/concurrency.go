@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+var ErrSemaphoreUnsupported = errors.New("limiter: storage does not implement storage.Semaphore")
+
+// ConcurrencyLimiter limita quantas requisições de uma mesma chave podem estar
+// em andamento ao mesmo tempo, em todas as instâncias. Pega clientes que
+// abrem poucas requisições longas, como long polling, que o contador por
+// janela não enxerga.
+//
+// Cada vaga é uma lease no storage que expira depois de lease e é renovada a
+// cada lease/3 enquanto a requisição estiver em andamento. Se a instância
+// morrer as vagas que ela segurava voltam sozinhas depois de no máximo lease.
+type ConcurrencyLimiter struct {
+	storage storage.Storage
+	// base é o storage sem contexto ligado, usado pela renovação.
+	base  storage.Storage
+	lease time.Duration
+}
+
+func NewConcurrencyLimiter(s storage.Storage, lease time.Duration) (*ConcurrencyLimiter, error) {
+	if _, ok := s.(storage.Semaphore); !ok {
+		return nil, ErrSemaphoreUnsupported
+	}
+	return &ConcurrencyLimiter{storage: s, base: s, lease: lease}, nil
+}
+
+// WithContext devolve um ConcurrencyLimiter cujas chamadas ao storage usam
+// ctx. A renovação das leases roda em segundo plano e não usa ctx.
+func (c *ConcurrencyLimiter) WithContext(ctx context.Context) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{storage: storage.WithContext(c.base, ctx), base: c.base, lease: c.lease}
+}
+
+// Acquire tenta ocupar uma das limit vagas da chave. Quando consegue, release
+// deve ser chamada ao fim da requisição para devolver a vaga; chamá-la mais de
+// uma vez não tem efeito.
+func (c *ConcurrencyLimiter) Acquire(key string, limit int) (release func(), acquired bool, err error) {
+	semaphore := c.storage.(storage.Semaphore)
+	key = "concurrency:" + key
+	id, err := leaseID()
+	if err != nil {
+		return nil, false, err
+	}
+
+	acquired, err = semaphore.AcquireLease(key, id, limit, c.lease)
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go c.renew(c.base.(storage.Semaphore), key, id, stop, done)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+			if err := semaphore.ReleaseLease(key, id); err != nil {
+				log.Printf("Error releasing concurrency lease for %s: %v", key, err)
+			}
+		})
+	}, true, nil
+}
+
+func (c *ConcurrencyLimiter) renew(semaphore storage.Semaphore, key, id string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(c.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := semaphore.RenewLease(key, id, c.lease); err != nil {
+				log.Printf("Error renewing concurrency lease for %s: %v", key, err)
+			}
+		}
+	}
+}
+
+func leaseID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -1,10 +1,53 @@
 package limiter
 
 import (
+	"context"
+	"errors"
 	"go-expert-rater-limit/storage"
 	"time"
 )
 
+var ErrRefundUnsupported = errors.New("limiter: refunds require a storage implementing storage.Counter")
+
+type Limiter interface {
+	IsAllowed(key string, limit int, duration time.Duration, blockTime time.Duration) bool
+	Check(key string, limit int, duration time.Duration, blockTime time.Duration) Result
+	CheckN(key string, n int, limit int, duration time.Duration, blockTime time.Duration) Result
+	Block(key string, duration time.Duration) error
+}
+
+// Refunder é implementado pelos limiters capazes de devolver unidades já
+// consumidas de uma janela, como as reservadas por rotas cobradas pela
+// resposta.
+type Refunder interface {
+	Refund(key string, n int, duration time.Duration) error
+}
+
+// Peeker é implementado pelos limiters capazes de consultar uma chave sem
+// consumir unidades nem bloqueá-la, usado pela fila do middleware para esperar
+// a janela liberar sem aplicar a penalidade de quem passa do limite.
+type Peeker interface {
+	Peek(key string, n int, limit int, duration time.Duration) Result
+}
+
+// Taker é implementado pelos limiters capazes de consumir unidades sem nunca
+// bloquear a chave: quando elas não cabem, a requisição é só rejeitada. Usado
+// pela fila do middleware para consumir depois da espera, quando várias
+// requisições acordam juntas e só algumas cabem na janela.
+type Taker interface {
+	Take(key string, n int, limit int, duration time.Duration) Result
+}
+
+// Result descreve a decisão tomada para uma requisição. RetryAfter só é
+// preenchido quando a requisição foi rejeitada.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Duration   time.Duration
+	RetryAfter time.Duration
+}
+
 type RateLimiter struct {
 	storage storage.Storage
 }
@@ -13,31 +56,198 @@ func NewRateLimiter(storage storage.Storage) *RateLimiter {
 	return &RateLimiter{storage: storage}
 }
 
+// WithContext devolve um RateLimiter cujas chamadas ao storage usam ctx.
+func (r *RateLimiter) WithContext(ctx context.Context) Limiter {
+	return &RateLimiter{storage: storage.WithContext(r.storage, ctx)}
+}
+
+// WithContext liga ctx ao limiter quando ele sabe repassá-lo ao storage e o
+// devolve inalterado caso contrário.
+func WithContext(l Limiter, ctx context.Context) Limiter {
+	if binder, ok := l.(interface {
+		WithContext(context.Context) Limiter
+	}); ok {
+		return binder.WithContext(ctx)
+	}
+	return l
+}
+
 func (r *RateLimiter) IsAllowed(key string, limit int, duration time.Duration, blockTime time.Duration) bool {
+	return r.Check(key, limit, duration, blockTime).Allowed
+}
+
+func (r *RateLimiter) Check(key string, limit int, duration time.Duration, blockTime time.Duration) Result {
+	return r.CheckN(key, 1, limit, duration, blockTime)
+}
+
+// CheckN consome n unidades de uma vez. Se elas não cabem no que resta da
+// janela a requisição é rejeitada, mas a chave só é bloqueada quando o limite
+// já está esgotado, como em Check. Com n igual a 0 nada é gravado: a chamada
+// apenas informa se a chave ainda tem cota.
+func (r *RateLimiter) CheckN(key string, n int, limit int, duration time.Duration, blockTime time.Duration) Result {
+	result := Result{Limit: limit, Duration: duration}
+
 	if r.storage.IsBlocked(key) {
-		return false
+		result.RetryAfter = blockedFor(r.storage, key, blockTime)
+		return result
 	}
 
 	current, err := r.storage.Get(key)
 	if err != nil {
-		return false
+		result.RetryAfter = duration
+		return result
 	}
+	result.Remaining = max(limit-current, 0)
 
 	if current >= limit {
+		result.RetryAfter = blockTime
 		err := r.storage.Block(key, blockTime)
 		if err != nil {
-			return false
+			return result
 		}
-		return false
+		return result
+	}
+
+	if current+n > limit {
+		result.RetryAfter = duration
+		return result
+	}
+	if n == 0 {
+		result.Allowed = true
+		return result
 	}
 
 	if current == 0 {
-		return r.storage.Set(key, 1, duration) == nil
+		err = r.storage.Set(key, n, duration)
+	} else {
+		err = incrBy(r.storage, key, n)
+	}
+	if err != nil {
+		result.RetryAfter = duration
+		return result
+	}
+
+	result.Allowed = true
+	result.Remaining = limit - current - n
+	return result
+}
+
+// Peek informa se n unidades ainda cabem na janela da chave, sem gravar nada.
+// Uma chave bloqueada é rejeitada com o TTL do bloqueio, quando o storage sabe
+// informá-lo; uma janela cheia, com a duração da janela.
+func (r *RateLimiter) Peek(key string, n int, limit int, duration time.Duration) Result {
+	result := Result{Limit: limit, Duration: duration}
+
+	if r.storage.IsBlocked(key) {
+		result.RetryAfter = blockedFor(r.storage, key, duration)
+		return result
+	}
+	current, err := r.storage.Get(key)
+	if err != nil {
+		result.RetryAfter = duration
+		return result
+	}
+	result.Remaining = max(limit-current, 0)
+	if current+n > limit {
+		result.RetryAfter = duration
+		return result
+	}
+	result.Allowed = true
+	return result
+}
+
+// Take consome n unidades se elas couberem na janela, sem bloquear a chave.
+// Com storage.Counter a reserva é atômica e desfeita quando passa do limite,
+// então requisições concorrentes não ultrapassam a janela; sem ele vale a
+// mesma leitura seguida de escrita de CheckN.
+func (r *RateLimiter) Take(key string, n int, limit int, duration time.Duration) Result {
+	result := Result{Limit: limit, Duration: duration}
+
+	if r.storage.IsBlocked(key) {
+		result.RetryAfter = blockedFor(r.storage, key, duration)
+		return result
+	}
+	counter, ok := r.storage.(storage.Counter)
+	if !ok {
+		if result = r.Peek(key, n, limit, duration); !result.Allowed || n == 0 {
+			return result
+		}
+		var err error
+		if current := limit - result.Remaining; current == 0 {
+			err = r.storage.Set(key, n, duration)
+		} else {
+			err = incrBy(r.storage, key, n)
+		}
+		if err != nil {
+			result.Allowed = false
+			result.RetryAfter = duration
+			return result
+		}
+		result.Remaining -= n
+		return result
 	}
 
-	return r.storage.Incr(key) == nil
+	current, err := counter.IncrWithExpiration(key, n, duration)
+	if err != nil {
+		result.RetryAfter = duration
+		return result
+	}
+	if current > limit {
+		_ = refund(r.storage, key, n, duration)
+		result.Remaining = max(limit-current+n, 0)
+		result.RetryAfter = duration
+		return result
+	}
+	result.Allowed = true
+	result.Remaining = limit - current
+	return result
 }
 
 func (r *RateLimiter) Block(key string, duration time.Duration) error {
 	return r.storage.Block(key, duration)
 }
+
+// Refund devolve n unidades à janela da chave. O bloqueio, se houver, é
+// mantido.
+func (r *RateLimiter) Refund(key string, n int, duration time.Duration) error {
+	return refund(r.storage, key, n, duration)
+}
+
+// blockedFor usa o TTL real do bloqueio quando o storage sabe informá-lo.
+func blockedFor(s storage.Storage, key string, blockTime time.Duration) time.Duration {
+	if reader, ok := s.(storage.BlockTTLReader); ok {
+		if ttl, err := reader.BlockTTL(key); err == nil && ttl > 0 {
+			return ttl
+		}
+	}
+	return blockTime
+}
+
+func incrBy(s storage.Storage, key string, n int) error {
+	if incrementer, ok := s.(storage.BatchIncrementer); ok {
+		return incrementer.IncrBy(key, n)
+	}
+	for i := 0; i < n; i++ {
+		if err := s.Incr(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refund desconta n unidades sem deixar o contador negativo, o que acontece
+// quando a janela expirou entre a cobrança e a devolução. Exige
+// storage.Counter: ler e regravar o contador perderia incrementos
+// concorrentes.
+func refund(s storage.Storage, key string, n int, duration time.Duration) error {
+	counter, ok := s.(storage.Counter)
+	if !ok {
+		return ErrRefundUnsupported
+	}
+	current, err := counter.IncrWithExpiration(key, -n, duration)
+	if err != nil || current >= 0 {
+		return err
+	}
+	_, err = counter.IncrWithExpiration(key, -current, duration)
+	return err
+}
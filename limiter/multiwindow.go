@@ -0,0 +1,125 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Window é um par limite/duração, como "500 por minuto".
+type Window struct {
+	Limit    int
+	Duration time.Duration
+}
+
+// WindowKey é a chave usada para contar a janela de índice i. A primeira janela
+// usa a própria chave, para que acrescentar janelas não zere os contadores já
+// existentes; as demais recebem a duração como sufixo.
+func WindowKey(key string, i int, window Window) string {
+	if i == 0 {
+		return key
+	}
+	return fmt.Sprintf("%s:%s", key, window.Duration)
+}
+
+// CheckWindows consome n unidades em todas as janelas da chave ao mesmo tempo.
+// Antes de consumir, todas são consultadas sem gravar nada: se alguma já está
+// esgotada ou não comporta n unidades, a requisição é rejeitada sem tocar nas
+// outras. O Result devolvido é o da janela mais restritiva: a que rejeitou com
+// o maior RetryAfter ou, se todas permitiram, a com menos unidades restantes.
+func CheckWindows(l Limiter, key string, n int, windows []Window, blockTime time.Duration) Result {
+	if len(windows) == 1 {
+		return l.CheckN(key, n, windows[0].Limit, windows[0].Duration, blockTime)
+	}
+
+	results := make([]Result, len(windows))
+	for i, w := range windows {
+		results[i] = l.CheckN(WindowKey(key, i, w), 0, w.Limit, w.Duration, blockTime)
+		if results[i].Allowed && results[i].Remaining < n {
+			results[i].Allowed = false
+			results[i].RetryAfter = w.Duration
+		}
+	}
+	if rejected, ok := mostRestrictive(results); ok && !rejected.Allowed {
+		return rejected
+	}
+
+	for i, w := range windows {
+		results[i] = l.CheckN(WindowKey(key, i, w), n, w.Limit, w.Duration, blockTime)
+	}
+	result, _ := mostRestrictive(results)
+	return result
+}
+
+// PeekWindows consulta todas as janelas da chave como CheckWindows, mas sem
+// consumir unidades nem bloquear a chave. ok é false quando o limiter não
+// implementa Peeker.
+func PeekWindows(l Limiter, key string, n int, windows []Window) (Result, bool) {
+	peeker, ok := l.(Peeker)
+	if !ok {
+		return Result{}, false
+	}
+	results := make([]Result, len(windows))
+	for i, w := range windows {
+		results[i] = peeker.Peek(WindowKey(key, i, w), n, w.Limit, w.Duration)
+	}
+	return mostRestrictive(results)
+}
+
+// TakeWindows consome n unidades de todas as janelas da chave sem bloqueá-la.
+// Se uma janela rejeita, as já consumidas são devolvidas (quando o limiter
+// implementa Refunder). ok é false quando o limiter não implementa Taker.
+func TakeWindows(l Limiter, key string, n int, windows []Window) (Result, bool) {
+	taker, ok := l.(Taker)
+	if !ok {
+		return Result{}, false
+	}
+	results := make([]Result, 0, len(windows))
+	for i, w := range windows {
+		result := taker.Take(WindowKey(key, i, w), n, w.Limit, w.Duration)
+		if !result.Allowed {
+			if refunder, ok := l.(Refunder); ok {
+				for j, taken := range windows[:i] {
+					_ = refunder.Refund(WindowKey(key, j, taken), n, taken.Duration)
+				}
+			}
+			return result, true
+		}
+		results = append(results, result)
+	}
+	return mostRestrictive(results)
+}
+
+// RefundWindows devolve n unidades a todas as janelas da chave, desfazendo um
+// CheckWindows permitido.
+func RefundWindows(l Limiter, key string, n int, windows []Window) error {
+	refunder, ok := l.(Refunder)
+	if !ok {
+		return ErrRefundUnsupported
+	}
+	var errs []error
+	for i, w := range windows {
+		if err := refunder.Refund(WindowKey(key, i, w), n, w.Duration); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func mostRestrictive(results []Result) (Result, bool) {
+	if len(results) == 0 {
+		return Result{}, false
+	}
+	worst := results[0]
+	for _, r := range results[1:] {
+		switch {
+		case worst.Allowed && !r.Allowed:
+			worst = r
+		case !worst.Allowed && !r.Allowed && r.RetryAfter > worst.RetryAfter:
+			worst = r
+		case worst.Allowed && r.Allowed && r.Remaining < worst.Remaining:
+			worst = r
+		}
+	}
+	return worst, true
+}
@@ -0,0 +1,135 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+// maxLookahead limita quantas janelas à frente Reserve procura por espaço.
+const maxLookahead = 100
+
+var (
+	ErrExceedsLimit            = errors.New("limiter: n exceeds the limit per window")
+	ErrReservationTooFar       = errors.New("limiter: no room in the next windows")
+	ErrReservationsUnsupported = errors.New("limiter: storage does not implement storage.Counter")
+)
+
+// WindowLimiter permite limit unidades por janela fixa de duration para cada
+// chave, nos moldes de golang.org/x/time/rate, mas com os contadores no
+// storage compartilhado: processos diferentes disputam a mesma cota. Exige um
+// storage que implemente storage.Counter para reservar atomicamente.
+type WindowLimiter struct {
+	storage  storage.Storage
+	limit    int
+	duration time.Duration
+}
+
+func NewWindowLimiter(storage storage.Storage, limit int, duration time.Duration) *WindowLimiter {
+	return &WindowLimiter{
+		storage:  storage,
+		limit:    limit,
+		duration: duration,
+	}
+}
+
+// Reservation representa n unidades reservadas em uma janela que começa
+// daqui a Delay.
+type Reservation struct {
+	limiter *WindowLimiter
+	key     string
+	n       int
+	delay   time.Duration
+}
+
+func (r *Reservation) Delay() time.Duration {
+	return r.delay
+}
+
+// Cancel devolve as unidades reservadas para que outros possam usá-las.
+func (r *Reservation) Cancel() error {
+	counter, ok := r.limiter.storage.(storage.Counter)
+	if !ok {
+		return ErrReservationsUnsupported
+	}
+	_, err := counter.IncrWithExpiration(r.key, -r.n, r.limiter.duration)
+	return err
+}
+
+// Reserve reserva n unidades na primeira janela com espaço, a partir da
+// atual, e informa quanto tempo falta para ela começar.
+func (l *WindowLimiter) Reserve(key string, n int) (*Reservation, error) {
+	if n > l.limit {
+		return nil, ErrExceedsLimit
+	}
+	counter, ok := l.storage.(storage.Counter)
+	if !ok {
+		return nil, ErrReservationsUnsupported
+	}
+
+	now := time.Now()
+	current := now.UnixNano() / int64(l.duration)
+
+	for i := int64(0); i < maxLookahead; i++ {
+		window := current + i
+		start := time.Unix(0, window*int64(l.duration))
+		windowKey := fmt.Sprintf("%s:%d", key, window)
+		expiration := start.Add(l.duration).Sub(now)
+
+		total, err := counter.IncrWithExpiration(windowKey, n, expiration)
+		if err != nil {
+			return nil, err
+		}
+		if total <= l.limit {
+			delay := start.Sub(now)
+			if delay < 0 {
+				delay = 0
+			}
+			return &Reservation{limiter: l, key: windowKey, n: n, delay: delay}, nil
+		}
+		if _, err := counter.IncrWithExpiration(windowKey, -n, expiration); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, ErrReservationTooFar
+}
+
+func (l *WindowLimiter) Wait(ctx context.Context, key string) error {
+	return l.WaitN(ctx, key, 1)
+}
+
+// WaitN bloqueia até que n unidades estejam disponíveis. Se a espera passar
+// do deadline do contexto, retorna erro imediatamente sem consumir a cota.
+func (l *WindowLimiter) WaitN(ctx context.Context, key string, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	reservation, err := l.Reserve(key, n)
+	if err != nil {
+		return err
+	}
+	if reservation.Delay() == 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && time.Now().Add(reservation.Delay()).After(deadline) {
+		_ = reservation.Cancel()
+		return fmt.Errorf("limiter: waiting %v would exceed the context deadline", reservation.Delay())
+	}
+
+	timer := time.NewTimer(reservation.Delay())
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		_ = reservation.Cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
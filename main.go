@@ -1,48 +1,341 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	// Fusos das cotas sem depender do tzdata da imagem.
+	_ "time/tzdata"
 
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"github.com/go-redis/redis/v8"
 	"github.com/joho/godotenv"
+	"google.golang.org/grpc"
 
+	"go-expert-rater-limit/admin"
 	"go-expert-rater-limit/config"
+	"go-expert-rater-limit/extauthz"
+	"go-expert-rater-limit/health"
 	"go-expert-rater-limit/limiter"
 	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/policy"
+	"go-expert-rater-limit/proxy"
+	"go-expert-rater-limit/quota"
 	"go-expert-rater-limit/storage"
+	"go-expert-rater-limit/tracing"
+	"go-expert-rater-limit/usage"
 )
 
 func main() {
-	err := godotenv.Load()
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		fatal("Error loading .env file", err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("Invalid configuration", err)
+	}
+	slog.SetDefault(newLogger(cfg))
+
+	if len(os.Args) > 1 && os.Args[1] == "export-usage" {
+		if err := exportUsage(cfg, os.Args[2:], os.Stdout); err != nil {
+			fatal("Usage export failed", err)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, cfg); err != nil {
+		fatal("Server failed", err)
+	}
+}
+
+// newLogger escreve JSON em stdout no nível de LOG_LEVEL. Como vira o logger
+// padrão, as mensagens do pacote log também saem em JSON.
+func newLogger(cfg *config.Config) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		level = slog.LevelInfo
+	}
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+func run(ctx context.Context, cfg *config.Config) error {
+	shutdownTracing, err := tracing.Setup(ctx, cfg.TracingExporter)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return err
 	}
-	cfg := config.Load()
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Error("Error flushing traces", "error", err)
+		}
+	}()
 
 	redisClient := redis.NewClient(&redis.Options{
 		Addr: cfg.RedisAddr,
 	})
+	defer func() {
+		if err := redisClient.Close(); err != nil {
+			slog.Error("Error closing Redis client", "error", err)
+		}
+	}()
 
 	store := storage.NewRedisStorage(redisClient)
-	rateLimiter := limiter.NewRateLimiter(store)
-	limiterMiddleware := middleware.NewRateLimiterMiddleware(
-		rateLimiter,
-		cfg.IPLimit,
-		cfg.TokenLimit,
-		cfg.IPDuration,
-		cfg.IPBlockTime,
-		cfg.TokenBlockTime,
-	)
+	var rateLimiter limiter.Limiter
+	switch cfg.Strategy {
+	case "batched":
+		batched := limiter.NewBatchedRateLimiter(store, cfg.SyncInterval)
+		defer batched.Close()
+		rateLimiter = batched
+	default:
+		rateLimiter = limiter.NewRateLimiter(store)
+	}
+	limiterMiddleware, err := middleware.NewRateLimiterMiddlewareWithRules(rateLimiter, rulesFromConfig(cfg))
+	if err != nil {
+		return err
+	}
 
-	mux := http.NewServeMux()
-	mux.Handle("/", limiterMiddleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	limiterMiddleware.SetDecisionLogger(slog.Default(), cfg.LogSampleRate)
+	limiterMiddleware.SetKeyHashSecret([]byte(cfg.LogKeyHashSecret))
+	if cfg.LimitedTemplate != "" {
+		tmpl, err := template.ParseFiles(cfg.LimitedTemplate)
+		if err != nil {
+			return fmt.Errorf("LIMITED_HTML_TEMPLATE: %w", err)
+		}
+		limiterMiddleware.SetLimitedTemplate(tmpl)
+	}
+	limiterMiddleware.SetQuotaTracker(quota.NewTracker(store))
+	if concurrencyEnabled(cfg) {
+		concurrency, err := limiter.NewConcurrencyLimiter(store, cfg.ConcurrencyLease)
+		if err != nil {
+			return err
+		}
+		limiterMiddleware.SetConcurrencyLimiter(concurrency)
+	}
+	usageRecorder := usage.NewRecorder(store, cfg.UsageRetention)
+	if cfg.UsageReporting {
+		limiterMiddleware.SetUsageRecorder(usageRecorder)
+		limiterMiddleware.SetFoldUnknownTokens(cfg.UsageFoldUnknownTokens)
+	}
+
+	if cfg.Policies {
+		policies := policy.NewRedisStore(redisClient, cfg.PolicyCacheTTL)
+		limiterMiddleware.SetPolicyStore(policies)
+		// Run só retorna quando ctx é cancelado: falhas na assinatura são
+		// registradas e repetidas com backoff.
+		go func() { _ = policies.Run(ctx) }()
+	}
+
+	go config.Watch(ctx, cfg.File, cfg.ReloadInterval, func() {
+		newCfg, err := config.Load()
+		if err != nil {
+			slog.Error("Configuration reload rejected, keeping the current rules", "error", err)
+			return
+		}
+		if err := limiterMiddleware.SetRules(rulesFromConfig(newCfg)); err != nil {
+			slog.Error("Configuration reload rejected, keeping the current rules", "error", err)
+			return
+		}
+		slog.Info("Configuration reloaded")
+	})
+
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		_, err := w.Write([]byte("Heeeey Rater Limit :)"))
 		if err != nil {
-			log.Println(err)
+			slog.Error("Error writing response", "error", err)
+		}
+	})
+	if len(cfg.Upstreams) > 0 {
+		reverseProxy, err := proxy.New(cfg.Upstreams)
+		if err != nil {
+			return err
 		}
-	})))
+		handler = reverseProxy
+		slog.Info("Proxying requests", "upstreams", cfg.Upstreams)
+	}
+
+	checker := health.NewChecker(cfg.ReadyTimeout)
+	checker.Add("storage", health.StorageCheck(store))
+
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", checker.Liveness())
+	mux.Handle("/readyz", checker.Readiness())
+	// /quota só informa o consumo da chave de quem pergunta, então fica na
+	// porta pública para o autoatendimento dos clientes.
+	mux.Handle("/quota", limiterMiddleware.QuotaHandler())
+	mux.Handle("/", limiterMiddleware.Handle(handler))
+
+	// Os endpoints internos ficam numa porta própria: na pública eles
+	// esconderiam caminhos do upstream e deixariam qualquer cliente consultar
+	// e consumir os limites de outras chaves.
+	adminMux := http.NewServeMux()
+	adminMux.Handle("/healthz", checker.Liveness())
+	adminMux.Handle("/readyz", checker.Readiness())
+	adminMux.Handle(extauthz.PathPrefix+"/", extauthz.NewHTTPHandler(limiterMiddleware))
+	adminMux.Handle("/check", extauthz.NewForwardAuthHandler(limiterMiddleware))
+	if len(cfg.TrustedProxies) == 0 {
+		slog.Warn("Forward auth accepts X-Forwarded-For and X-Real-IP from any caller; set TRUSTED_PROXIES to the Traefik/nginx addresses")
+	}
+	adminMux.Handle("/admin/quota", admin.RequireToken(cfg.AdminToken, limiterMiddleware.AdminQuotaHandler()))
+	adminMux.Handle("/admin/usage", admin.RequireToken(cfg.AdminToken, usage.NewExportHandler(usageRecorder)))
+
+	if cfg.GRPCPort != "" {
+		listener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			return err
+		}
+		grpcServer := grpc.NewServer()
+		rlsv3.RegisterRateLimitServiceServer(grpcServer, extauthz.NewRateLimitService(limiterMiddleware))
+		defer grpcServer.GracefulStop()
+		go func() {
+			slog.Info("Envoy rate limit service starting", "port", cfg.GRPCPort)
+			if err := grpcServer.Serve(listener); err != nil {
+				slog.Error("gRPC server stopped", "error", err)
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:              ":" + cfg.ServerPort,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	adminServer := &http.Server{
+		Addr:              ":" + cfg.AdminPort,
+		Handler:           adminMux,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+
+	serverErr := make(chan error, 2)
+	go func() {
+		slog.Info("Server starting", "port", cfg.ServerPort)
+		serverErr <- server.ListenAndServe()
+	}()
+	go func() {
+		slog.Info("Admin server starting", "port", cfg.AdminPort)
+		serverErr <- adminServer.ListenAndServe()
+	}()
+
+	checker.SetReady(true)
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+	checker.SetReady(false)
+
+	slog.Info("Shutting down, draining open connections")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := errors.Join(server.Shutdown(shutdownCtx), adminServer.Shutdown(shutdownCtx)); err != nil {
+		return err
+	}
+	slog.Info("Server stopped")
+	return nil
+}
+
+func rulesFromConfig(cfg *config.Config) middleware.Rules {
+	plans := make(map[string]middleware.TokenPlan, len(cfg.Tokens))
+	for token, planName := range cfg.Tokens {
+		plan := cfg.Plans[planName]
+		plans[token] = middleware.TokenPlan{
+			Limit:         plan.Limit,
+			Duration:      plan.Duration,
+			BlockTime:     plan.BlockTime,
+			Windows:       limiterWindows(plan.Windows),
+			Quota:         quotaFromConfig(plan.Quota),
+			Concurrency:   plan.Concurrency,
+			QueueMaxDelay: plan.QueueMaxDelay,
+		}
+	}
+
+	routeCosts := make([]middleware.RouteCost, len(cfg.Routes))
+	for i, route := range cfg.Routes {
+		routeCosts[i] = middleware.RouteCost{
+			Method:               route.Method,
+			PathPrefix:           route.Path,
+			Cost:                 route.Cost,
+			QueryParam:           route.QueryParam,
+			BodyBytesPerUnit:     route.BodyBytesPerUnit,
+			OnlyFailures:         route.OnlyFailures,
+			ResponseBytesPerUnit: route.ResponseBytesPerUnit,
+		}
+	}
+
+	return middleware.Rules{
+		IPLimit:          cfg.IPLimit,
+		TokenLimit:       cfg.TokenLimit,
+		IPDuration:       cfg.IPDuration,
+		IPBlockTime:      cfg.IPBlockTime,
+		TokenBlockTime:   cfg.TokenBlockTime,
+		TokenPlans:       plans,
+		Allowlist:        cfg.Allowlist,
+		Denylist:         cfg.Denylist,
+		TrustedProxies:   cfg.TrustedProxies,
+		RouteCosts:       routeCosts,
+		IPWindows:        limiterWindows(cfg.IPWindows),
+		TokenWindows:     limiterWindows(cfg.TokenWindows),
+		IPQuota:          quotaFromConfig(cfg.IPQuota),
+		TokenQuota:       quotaFromConfig(cfg.TokenQuota),
+		IPConcurrency:    cfg.IPConcurrency,
+		TokenConcurrency: cfg.TokenConcurrency,
+		Queue:            middleware.QueueRules{MaxDelay: cfg.QueueMaxDelay, Size: cfg.QueueSize},
+		Skip:             middleware.SkipRules(cfg.Skip),
+	}
+}
 
-	log.Printf("Server starting on port %s", cfg.ServerPort)
-	log.Fatal(http.ListenAndServe(":"+cfg.ServerPort, mux))
+// concurrencyEnabled indica se alguma regra limita requisições em andamento.
+// Só é consultada na partida: recarregar a configuração ajusta os limites, mas
+// não liga o limiter de concorrência.
+func concurrencyEnabled(cfg *config.Config) bool {
+	if cfg.IPConcurrency > 0 || cfg.TokenConcurrency > 0 {
+		return true
+	}
+	for _, plan := range cfg.Plans {
+		if plan.Concurrency > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// quotaFromConfig assume uma configuração já validada por config.Load.
+func quotaFromConfig(q config.Quota) quota.Quota {
+	loc, err := time.LoadLocation(q.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	return quota.Quota{Limit: q.Limit, Period: quota.Period(q.Period), Location: loc}
+}
+
+func limiterWindows(windows []config.Window) []limiter.Window {
+	out := make([]limiter.Window, len(windows))
+	for i, w := range windows {
+		out[i] = limiter.Window(w)
+	}
+	return out
 }
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go-expert-rater-limit/limiter"
+)
+
+// SetConcurrencyLimiter ativa os limites de requisições simultâneas
+// (IPConcurrency, TokenConcurrency e TokenPlan.Concurrency). Deve ser chamado
+// antes de servir requisições.
+func (m *RateLimiterMiddleware) SetConcurrencyLimiter(c *limiter.ConcurrencyLimiter) {
+	m.concurrency = c
+}
+
+func (rules *ruleSet) concurrencyFor(key string) int {
+	token, ok := strings.CutPrefix(key, "token:")
+	if !ok {
+		return rules.IPConcurrency
+	}
+	if plan, ok := rules.TokenPlans[token]; ok {
+		return plan.Concurrency
+	}
+	return rules.TokenConcurrency
+}
+
+// acquireSlot ocupa uma vaga de concorrência da chave. Como no limiter, uma
+// falha do storage rejeita a requisição, tratada como se não houvesse vaga
+// para que o cliente receba o mesmo Retry-After.
+func (m *RateLimiterMiddleware) acquireSlot(ctx context.Context, rules *ruleSet, decision *Decision) {
+	limit := rules.concurrencyFor(decision.Key)
+	if m.concurrency == nil || limit <= 0 {
+		return
+	}
+	release, acquired, err := m.concurrency.WithContext(ctx).Acquire(decision.Key, limit)
+	if err != nil {
+		log.Printf("Error acquiring concurrency slot for %s: %v", decision.Key, err)
+	}
+	if err != nil || !acquired {
+		decision.Status = http.StatusTooManyRequests
+		decision.concurrency = limit
+		return
+	}
+	decision.release = release
+}
+
+// SetConcurrencyHeaders escreve X-Concurrency-Limit quando a requisição foi
+// barrada pelo limite de requisições simultâneas, com Retry-After de 1
+// segundo: não há como saber quando outra requisição vai terminar.
+func SetConcurrencyHeaders(h http.Header, decision Decision) {
+	if decision.concurrency <= 0 {
+		return
+	}
+	h.Set("X-Concurrency-Limit", strconv.Itoa(decision.concurrency))
+	h.Set("Retry-After", "1")
+}
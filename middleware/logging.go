@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+)
+
+// SetDecisionLogger faz o middleware registrar suas decisões em logger.
+// Rejeições são sempre registradas; das requisições permitidas só a fração
+// allowedSampleRate (de 0 a 1). As chaves aparecem apenas como hash, para não
+// expor tokens nem IPs nos logs (ver SetKeyHashSecret). Deve ser chamado antes
+// de servir requisições.
+func (m *RateLimiterMiddleware) SetDecisionLogger(logger *slog.Logger, allowedSampleRate float64) {
+	m.logger = logger
+	m.sampleRate = allowedSampleRate
+}
+
+// SetKeyHashSecret define o segredo do HMAC que gera o key_hash de logs e
+// spans. Sem ele cada processo sorteia o seu, o que basta para correlacionar
+// as decisões de uma instância; com o mesmo segredo em todas, o hash de uma
+// chave é igual entre instâncias e reinícios. Deve ser chamado antes de servir
+// requisições.
+func (m *RateLimiterMiddleware) SetKeyHashSecret(secret []byte) {
+	m.keyHashSecret = secret
+}
+
+func (m *RateLimiterMiddleware) logDecision(decision Decision) {
+	if m.logger == nil {
+		return
+	}
+	if decision.Status == http.StatusOK && (m.sampleRate <= 0 || rand.Float64() >= m.sampleRate) {
+		return
+	}
+
+	keyType, _, _ := strings.Cut(decision.Key, ":")
+	attrs := []slog.Attr{
+		slog.String("key_type", keyType),
+		slog.String("key_hash", m.hashKey(decision.Key)),
+		slog.String("rule", decision.rule),
+		slog.String("reason", decisionReason(decision)),
+		slog.Int("status", decision.Status),
+		slog.Int("cost", decision.Cost),
+	}
+	if decision.Result.Limit > 0 {
+		attrs = append(attrs,
+			slog.Int("limit", decision.Result.Limit),
+			slog.String("window", decision.Result.Duration.String()),
+			slog.Int("remaining", decision.Result.Remaining),
+		)
+	}
+	if decision.Result.RetryAfter > 0 {
+		attrs = append(attrs, slog.String("retry_after", decision.Result.RetryAfter.String()))
+	}
+	if decision.Quota != nil {
+		attrs = append(attrs, slog.Int("quota_remaining", decision.Quota.Remaining))
+	}
+	if decision.concurrency > 0 {
+		attrs = append(attrs, slog.Int("concurrency_limit", decision.concurrency))
+	}
+	if decision.queued > 0 {
+		attrs = append(attrs, slog.String("queued", decision.queued.String()))
+	}
+	m.logger.LogAttrs(context.Background(), slog.LevelInfo, "rate limit decision", attrs...)
+}
+
+func decisionReason(decision Decision) string {
+	switch {
+	case decision.Status == http.StatusTooManyRequests && decision.concurrency > 0:
+		return "concurrency_limited"
+	case decision.Status == http.StatusTooManyRequests:
+		return "rate_limited"
+	case decision.Status == http.StatusForbidden && decision.Quota != nil:
+		return "quota_exceeded"
+	case decision.Status == http.StatusForbidden:
+		return "denylisted"
+	case decision.rule == "allowlist":
+		return "allowlisted"
+	case decision.rule == "skip":
+		return "skipped"
+	default:
+		return "allowed"
+	}
+}
+
+// processKeyHashSecret é o segredo de hashKey quando SetKeyHashSecret não foi
+// chamado.
+var processKeyHashSecret = func() []byte {
+	secret := make([]byte, 32)
+	if _, err := crand.Read(secret); err != nil {
+		panic(err)
+	}
+	return secret
+}()
+
+// hashKey devolve os primeiros 8 bytes do HMAC-SHA256 da chave em
+// hexadecimal: suficiente para correlacionar requisições da mesma chave. Um
+// hash sem segredo seria revertido testando os IPs possíveis ou tokens
+// vazados.
+func (m *RateLimiterMiddleware) hashKey(key string) string {
+	secret := m.keyHashSecret
+	if len(secret) == 0 {
+		secret = processKeyHashSecret
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
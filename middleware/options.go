@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/policy"
+	"go-expert-rater-limit/quota"
+	"go-expert-rater-limit/usage"
+)
+
+// KeyExtractor devolve a chave de uma requisição. Chaves com prefixo "token:"
+// usam os limites de token e as demais os de IP; uma chave vazia cai na regra
+// padrão (API_KEY, senão IP).
+type KeyExtractor func(r *http.Request) string
+
+// Skipper decide quais requisições passam direto, sem consultar a allowlist,
+// limites ou o storage. A denylist é verificada antes.
+type Skipper func(r *http.Request) bool
+
+// Option configura o middleware criado por New.
+type Option func(*RateLimiterMiddleware, *Rules)
+
+// New cria o middleware a partir de opções, para uso como biblioteca. Sem
+// opções valem os padrões do serviço: 5 requisições por segundo por IP e 10
+// por token, com bloqueios de 5 e 6 minutos.
+func New(l limiter.Limiter, opts ...Option) (*RateLimiterMiddleware, error) {
+	rules := Rules{
+		IPLimit:        5,
+		TokenLimit:     10,
+		IPDuration:     time.Second,
+		IPBlockTime:    5 * time.Minute,
+		TokenBlockTime: 6 * time.Minute,
+	}
+	m := &RateLimiterMiddleware{limiter: l}
+	for _, opt := range opts {
+		opt(m, &rules)
+	}
+	if err := m.SetRules(rules); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WithRules substitui todas as regras; opções de regra posteriores ainda se
+// aplicam por cima.
+func WithRules(rules Rules) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { *r = rules }
+}
+
+// WithIPLimit limita cada IP a limit requisições por window. A mesma janela
+// vale para os tokens sem plano.
+func WithIPLimit(limit int, window time.Duration) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) {
+		r.IPLimit, r.IPDuration = limit, window
+	}
+}
+
+func WithTokenLimit(limit int) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.TokenLimit = limit }
+}
+
+// WithBlockTime define por quanto tempo IPs e tokens ficam bloqueados depois
+// de estourar o limite.
+func WithBlockTime(ip, token time.Duration) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) {
+		r.IPBlockTime, r.TokenBlockTime = ip, token
+	}
+}
+
+func WithTokenPlans(plans map[string]TokenPlan) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.TokenPlans = plans }
+}
+
+func WithAllowlist(entries ...string) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.Allowlist = append(r.Allowlist, entries...) }
+}
+
+func WithDenylist(entries ...string) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.Denylist = append(r.Denylist, entries...) }
+}
+
+// WithTrustedProxies informa os proxies reversos cujos X-Real-IP e
+// X-Forwarded-For identificam o cliente.
+func WithTrustedProxies(entries ...string) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.TrustedProxies = append(r.TrustedProxies, entries...) }
+}
+
+func WithRouteCosts(costs ...RouteCost) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.RouteCosts = append(r.RouteCosts, costs...) }
+}
+
+func WithIPWindows(windows ...limiter.Window) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.IPWindows = append(r.IPWindows, windows...) }
+}
+
+func WithTokenWindows(windows ...limiter.Window) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.TokenWindows = append(r.TokenWindows, windows...) }
+}
+
+// WithQuotas ativa as cotas de longo prazo de IPs e tokens, guardadas em
+// tracker.
+func WithQuotas(tracker *quota.Tracker, ip, token quota.Quota) Option {
+	return func(m *RateLimiterMiddleware, r *Rules) {
+		m.quotas = tracker
+		r.IPQuota, r.TokenQuota = ip, token
+	}
+}
+
+// WithConcurrency limita as requisições simultâneas de cada IP e de cada
+// token (0 desativa), com as vagas guardadas em c.
+func WithConcurrency(c *limiter.ConcurrencyLimiter, ip, token int) Option {
+	return func(m *RateLimiterMiddleware, r *Rules) {
+		m.concurrency = c
+		r.IPConcurrency, r.TokenConcurrency = ip, token
+	}
+}
+
+// WithQueue segura as requisições acima do limite por até maxDelay, com no
+// máximo size delas esperando ao mesmo tempo, antes de responder 429.
+func WithQueue(maxDelay time.Duration, size int) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) {
+		r.Queue = QueueRules{MaxDelay: maxDelay, Size: size}
+	}
+}
+
+// WithKeyExtractor troca a forma de identificar quem faz a requisição, por
+// exemplo pelo usuário autenticado em vez do IP.
+func WithKeyExtractor(extract KeyExtractor) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.keyExtractor = extract }
+}
+
+// WithSkipper acrescenta Skippers; a requisição passa direto se qualquer um
+// deles casar. Veja SkipPaths, SkipPreflight e afins.
+func WithSkipper(skippers ...Skipper) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.skippers = append(m.skippers, skippers...) }
+}
+
+// WithSkipRules define o tráfego que passa direto a partir de SkipRules, que,
+// ao contrário de WithSkipper, são trocadas junto com as regras em SetRules.
+func WithSkipRules(skip SkipRules) Option {
+	return func(_ *RateLimiterMiddleware, r *Rules) { r.Skip = skip }
+}
+
+func WithOnLimited(handler LimitedHandler) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.onLimited = handler }
+}
+
+func WithLimitedTemplate(tmpl *template.Template) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.limitedTemplate = tmpl }
+}
+
+func WithDecisionLogger(logger *slog.Logger, allowedSampleRate float64) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.SetDecisionLogger(logger, allowedSampleRate) }
+}
+
+func WithKeyHashSecret(secret []byte) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.SetKeyHashSecret(secret) }
+}
+
+func WithPolicyStore(store policy.Store) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.policies = store }
+}
+
+func WithUsageRecorder(recorder *usage.Recorder) Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.usage = recorder }
+}
+
+// WithFoldUnknownTokens soma no relatório de uso os tokens sem plano nem
+// política dinâmica (ver SetFoldUnknownTokens).
+func WithFoldUnknownTokens() Option {
+	return func(m *RateLimiterMiddleware, _ *Rules) { m.SetFoldUnknownTokens(true) }
+}
+
+// requestKey usa o KeyExtractor configurado, com a regra padrão como reserva.
+func (m *RateLimiterMiddleware) requestKey(r *http.Request) string {
+	r = m.rules.Load().withClientIP(r)
+	if m.keyExtractor != nil {
+		if key := m.keyExtractor(r); key != "" {
+			return key
+		}
+	}
+	return requestKey(r)
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+)
+
+// DefaultQueueSize é quantas requisições podem esperar na fila ao mesmo tempo
+// quando QueueRules.Size não é informado.
+const DefaultQueueSize = 100
+
+// QueueRules faz Handle (e os adaptadores) segurar uma requisição acima do
+// limite até a janela liberar, em vez de responder 429 na hora. Serve para
+// suavizar o tráfego de clientes internos, que preferem esperar a tratar a
+// rejeição. A requisição só é rejeitada quando a espera passaria de MaxDelay,
+// quando a fila já tem Size requisições ou quando o cliente desiste.
+//
+// Enquanto espera, a requisição só consulta o limiter (ver limiter.Peeker),
+// sem consumir unidades nem bloquear a chave, e consome sem bloqueio (ver
+// limiter.Taker) quando a janela tem espaço; como não há como saber quando a
+// janela termina, cada espera dura a janela inteira, então a fila só engata
+// quando a duração da janela cabe em MaxDelay. Chaves já bloqueadas, cotas e
+// o limite de concorrência não entram na fila.
+type QueueRules struct {
+	// MaxDelay é a espera máxima por requisição; 0 desativa a fila.
+	MaxDelay time.Duration
+	// Size limita as requisições esperando ao mesmo tempo nesta instância (0
+	// usa DefaultQueueSize).
+	Size int
+}
+
+func (rules *ruleSet) queueDelayFor(key string) time.Duration {
+	if token, ok := strings.CutPrefix(key, "token:"); ok {
+		if plan, ok := rules.TokenPlans[token]; ok && plan.QueueMaxDelay > 0 {
+			return plan.QueueMaxDelay
+		}
+	}
+	return rules.Queue.MaxDelay
+}
+
+// await segura a requisição enquanto a janela não comporta cost unidades. A
+// espera usa peek, que não grava nada; quando há espaço, take consome sem
+// bloquear a chave, porque várias requisições acordam juntas e só algumas
+// cabem na janela. Com a fila cheia ou quando a primeira espera já passaria
+// de maxDelay, a requisição segue direto para check e recebe o 429 (e o
+// bloqueio) de sempre. Quem esperou sem conseguir espaço recebe 429 sem novo
+// bloqueio.
+func (m *RateLimiterMiddleware) await(ctx context.Context, maxDelay time.Duration, size int, peek, take func() limiter.Result, check func() limiter.Result) (limiter.Result, time.Duration) {
+	if maxDelay <= 0 {
+		return check(), 0
+	}
+	result := peek()
+	if result.Allowed || result.RetryAfter <= 0 || result.RetryAfter > maxDelay {
+		return check(), 0
+	}
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	if m.waiting.Add(1) > int64(size) {
+		m.waiting.Add(-1)
+		return check(), 0
+	}
+	defer m.waiting.Add(-1)
+
+	start := time.Now()
+	deadline := start.Add(maxDelay)
+	for {
+		timer := time.NewTimer(result.RetryAfter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, time.Since(start)
+		case <-timer.C:
+		}
+		if result = peek(); result.Allowed {
+			if result = take(); result.Allowed {
+				return result, time.Since(start)
+			}
+		}
+		if result.RetryAfter <= 0 || time.Now().Add(result.RetryAfter).After(deadline) {
+			return result, time.Since(start)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/quota"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetQuotaTracker ativa as cotas de longo prazo (IPQuota, TokenQuota e
+// TokenPlan.Quota). Deve ser chamado antes de servir requisições.
+func (m *RateLimiterMiddleware) SetQuotaTracker(tracker *quota.Tracker) {
+	m.quotas = tracker
+}
+
+func (rules *ruleSet) quotaFor(key string) quota.Quota {
+	token, ok := strings.CutPrefix(key, "token:")
+	if !ok {
+		return rules.IPQuota
+	}
+	if plan, ok := rules.TokenPlans[token]; ok {
+		return plan.Quota
+	}
+	return rules.TokenQuota
+}
+
+// applyQuota consome o custo da decisão na cota da chave. Como no limiter,
+// uma falha do storage rejeita a requisição. As janelas já foram consumidas
+// antes da cota; quando ela rejeita, as unidades voltam para elas, para que
+// uma cota esgotada não gaste também o limite de curto prazo.
+func (m *RateLimiterMiddleware) applyQuota(ctx context.Context, decision *Decision) {
+	if m.quotas == nil || !decision.quota.Enabled() {
+		return
+	}
+	usage, err := m.quotas.WithContext(ctx).Consume(decision.Key, decision.quota, decision.Cost)
+	if err != nil {
+		decision.Status = http.StatusTooManyRequests
+		decision.Result.Allowed = false
+		decision.Result.RetryAfter = decision.Result.Duration
+		m.refundWindows(ctx, *decision)
+		return
+	}
+	decision.Quota = &usage
+	if !usage.Allowed {
+		decision.Status = http.StatusForbidden
+		m.refundWindows(ctx, *decision)
+	}
+}
+
+// refundWindows devolve às janelas o custo de uma decisão rejeitada depois de
+// consumi-las. Limiters sem Refunder ficam com o consumo.
+func (m *RateLimiterMiddleware) refundWindows(ctx context.Context, decision Decision) {
+	l := limiter.WithContext(m.limiter, ctx)
+	err := limiter.RefundWindows(l, decision.Key, decision.Cost, decision.windows)
+	if err != nil && !errors.Is(err, limiter.ErrRefundUnsupported) {
+		log.Printf("Error refunding %s: %v", decision.Key, err)
+	}
+}
+
+// consumeQuota cobra n unidades depois da resposta, esgotando a cota quando
+// elas não cabem no que resta.
+func (m *RateLimiterMiddleware) consumeQuota(ctx context.Context, key string, q quota.Quota, n int) {
+	quotas := m.quotas.WithContext(ctx)
+	usage, err := quotas.Consume(key, q, n)
+	if err == nil && !usage.Allowed && usage.Remaining > 0 {
+		_, err = quotas.Consume(key, q, usage.Remaining)
+	}
+	if err != nil {
+		log.Printf("Error consuming quota for %s: %v", key, err)
+	}
+}
+
+// SetQuotaHeaders escreve X-Quota-Limit, X-Quota-Remaining e X-Quota-Reset
+// (unix, em segundos) e, com a cota esgotada, Retry-After até a renovação.
+func SetQuotaHeaders(h http.Header, usage *quota.Usage) {
+	if usage == nil {
+		return
+	}
+	h.Set("X-Quota-Limit", strconv.Itoa(usage.Limit))
+	h.Set("X-Quota-Remaining", strconv.Itoa(usage.Remaining))
+	h.Set("X-Quota-Reset", strconv.FormatInt(usage.ResetAt.Unix(), 10))
+	if !usage.Allowed {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(time.Until(usage.ResetAt).Seconds()))))
+	}
+}
+
+// QuotaHandler responde com o consumo de cota de quem fez a requisição
+// (API_KEY ou IP). O parâmetro opcional at (AAAA-MM-DD) consulta o período
+// que contém aquela data, como o mês anterior.
+func (m *RateLimiterMiddleware) QuotaHandler() http.Handler {
+	return m.quotaHandler(m.requestKey)
+}
+
+// AdminQuotaHandler consulta a chave informada em ?key= (ex.: token:abc123).
+// Não tem autenticação própria e deve ser exposto atrás de admin.RequireToken.
+func (m *RateLimiterMiddleware) AdminQuotaHandler() http.Handler {
+	return m.quotaHandler(func(r *http.Request) string {
+		return r.URL.Query().Get("key")
+	})
+}
+
+func (m *RateLimiterMiddleware) quotaHandler(keyOf func(*http.Request) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		key := keyOf(r)
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		q := m.rules.Load().quotaFor(key)
+		if m.quotas == nil || !q.Enabled() {
+			http.Error(w, "no quota configured for "+key, http.StatusNotFound)
+			return
+		}
+
+		at := time.Now()
+		if value := r.URL.Query().Get("at"); value != "" {
+			loc := q.Location
+			if loc == nil {
+				loc = time.UTC
+			}
+			parsed, err := time.ParseInLocation("2006-01-02", value, loc)
+			if err != nil {
+				http.Error(w, "at must be a date in the YYYY-MM-DD format", http.StatusBadRequest)
+				return
+			}
+			at = parsed
+		}
+
+		usage, err := m.quotas.WithContext(r.Context()).Usage(key, q, at)
+		if err != nil {
+			http.Error(w, "quota storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(usage); err != nil {
+			return
+		}
+	})
+}
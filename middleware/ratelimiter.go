@@ -1,84 +1,830 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/policy"
+	"go-expert-rater-limit/quota"
+	"go-expert-rater-limit/usage"
+	"html/template"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
+type TokenPlan struct {
+	Limit     int
+	Duration  time.Duration
+	BlockTime time.Duration
+	// Windows são janelas adicionais à de Limit/Duration (ex.: 500/min, 50k/dia).
+	Windows []limiter.Window
+	// Quota é a cota de longo prazo do plano, zerada a cada dia ou mês.
+	Quota quota.Quota
+	// Concurrency limita as requisições simultâneas do token (0 desativa).
+	Concurrency int
+	// QueueMaxDelay, quando maior que zero, substitui Rules.Queue.MaxDelay
+	// para os tokens do plano.
+	QueueMaxDelay time.Duration
+}
+
+// RouteCost define quantas unidades uma requisição consome. A primeira regra
+// cujo método e prefixo casarem (prefixos mais longos primeiro) é usada; sem
+// regra, o custo é 1.
+//
+// Com OnlyFailures, ResponseBytesPerUnit ou ResponseFunc a cobrança é
+// acertada depois da resposta: antes de chamar o handler o middleware já
+// consome o custo da requisição (ao menos 1), para que tentativas em paralelo
+// não passem todas antes de a primeira falha ser contada, e depois cobra ou
+// devolve a diferença para o custo calculado a partir da resposta.
+type RouteCost struct {
+	Method     string
+	PathPrefix string
+	// Cost é o custo fixo da rota (0 equivale a 1).
+	Cost int
+	// QueryParam, se presente e com inteiro positivo, eleva o custo a esse
+	// valor (ex.: ?pages=5); Cost continua sendo o mínimo, para que o cliente
+	// não pague menos mandando ?pages=1.
+	QueryParam string
+	// BodyBytesPerUnit soma uma unidade a cada N bytes de corpo: pelo
+	// Content-Length ou, num corpo chunked, pelos bytes lidos pelo handler,
+	// cobrados depois dele.
+	BodyBytesPerUnit int64
+	// Func, quando definida, calcula o custo e ignora os demais campos.
+	Func func(r *http.Request) int
+	// OnlyFailures só cobra respostas com status >= 400 (ex.: logins que falharam).
+	OnlyFailures bool
+	// ResponseBytesPerUnit soma uma unidade a cada N bytes escritos na resposta.
+	ResponseBytesPerUnit int64
+	// ResponseFunc, quando definida, calcula o custo a partir da resposta e
+	// ignora os demais campos. Retornar 0 não consome nada.
+	ResponseFunc func(r *http.Request, status int, bytes int64) int
+}
+
+type Rules struct {
+	IPLimit        int
+	TokenLimit     int
+	IPDuration     time.Duration
+	IPBlockTime    time.Duration
+	TokenBlockTime time.Duration
+	TokenPlans     map[string]TokenPlan
+	Allowlist      []string
+	Denylist       []string
+	// TrustedProxies são os IPs ou CIDRs dos proxies reversos à frente do
+	// serviço. Quando informado, X-Real-IP e X-Forwarded-For só são lidos em
+	// requisições vindas deles e as demais usam RemoteAddr; vazio, os
+	// cabeçalhos são aceitos de qualquer origem, como antes.
+	TrustedProxies []string
+	RouteCosts     []RouteCost
+	// IPWindows e TokenWindows somam janelas às de IPLimit e TokenLimit; a
+	// requisição é rejeitada se qualquer uma delas estiver esgotada.
+	IPWindows    []limiter.Window
+	TokenWindows []limiter.Window
+	// IPQuota e TokenQuota são cotas de longo prazo, só aplicadas quando há um
+	// quota.Tracker configurado com SetQuotaTracker.
+	IPQuota    quota.Quota
+	TokenQuota quota.Quota
+	// IPConcurrency e TokenConcurrency limitam as requisições simultâneas de
+	// cada chave (0 desativa); só valem com um ConcurrencyLimiter configurado
+	// em SetConcurrencyLimiter.
+	IPConcurrency    int
+	TokenConcurrency int
+	// Skip é o tráfego que passa direto, sem limite.
+	Skip SkipRules
+	// Queue faz Handle segurar requisições acima do limite em vez de
+	// rejeitá-las de imediato.
+	Queue QueueRules
+}
+
 type RateLimiterMiddleware struct {
-	limiter        *limiter.RateLimiter
-	ipLimit        int
-	tokenLimit     int
-	ipDuration     time.Duration
-	ipBlockTime    time.Duration
-	tokenBlockTime time.Duration
+	limiter  limiter.Limiter
+	rules    atomic.Pointer[ruleSet]
+	policies policy.Store
+	quotas   *quota.Tracker
+	usage    *usage.Recorder
+	// foldUnknownTokens soma no relatório de uso os tokens sem plano nem
+	// política numa só linha (ver SetFoldUnknownTokens).
+	foldUnknownTokens bool
+	logger            *slog.Logger
+	// keyHashSecret é o segredo do key_hash; vazio usa processKeyHashSecret.
+	keyHashSecret []byte
+	// sampleRate é a fração das requisições permitidas que vai para o log.
+	sampleRate      float64
+	onLimited       LimitedHandler
+	limitedTemplate *template.Template
+	keyExtractor    KeyExtractor
+	skippers        []Skipper
+	concurrency     *limiter.ConcurrencyLimiter
+	// waiting conta as requisições seguradas na fila, limitadas por Queue.Size.
+	waiting atomic.Int64
+}
+
+type ruleSet struct {
+	Rules
+	allowlist      []*net.IPNet
+	denylist       []*net.IPNet
+	trustedProxies []*net.IPNet
+	routeCosts     []RouteCost
+	skippers       []Skipper
 }
 
 func NewRateLimiterMiddleware(
-	limiter *limiter.RateLimiter,
+	limiter limiter.Limiter,
 	ipLimit, tokenLimit int,
 	ipDuration, ipBlockTime, tokenBlockTime time.Duration,
 ) *RateLimiterMiddleware {
-	return &RateLimiterMiddleware{
-		limiter:        limiter,
-		ipLimit:        ipLimit,
-		tokenLimit:     tokenLimit,
-		ipDuration:     ipDuration,
-		ipBlockTime:    ipBlockTime,
-		tokenBlockTime: tokenBlockTime,
+	m, _ := NewRateLimiterMiddlewareWithRules(limiter, Rules{
+		IPLimit:        ipLimit,
+		TokenLimit:     tokenLimit,
+		IPDuration:     ipDuration,
+		IPBlockTime:    ipBlockTime,
+		TokenBlockTime: tokenBlockTime,
+	})
+	return m
+}
+
+func NewRateLimiterMiddlewareWithRules(limiter limiter.Limiter, rules Rules) (*RateLimiterMiddleware, error) {
+	m := &RateLimiterMiddleware{limiter: limiter}
+	if err := m.SetRules(rules); err != nil {
+		return nil, err
 	}
+	return m, nil
+}
+
+// SetRules troca as regras atomicamente. Requisições em andamento terminam com
+// as regras antigas; se as novas forem inválidas as antigas continuam valendo.
+func (m *RateLimiterMiddleware) SetRules(rules Rules) error {
+	allowlist, err := parseNets(rules.Allowlist)
+	if err != nil {
+		return fmt.Errorf("allowlist: %w", err)
+	}
+	denylist, err := parseNets(rules.Denylist)
+	if err != nil {
+		return fmt.Errorf("denylist: %w", err)
+	}
+	trustedProxies, err := parseNets(rules.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("trusted proxies: %w", err)
+	}
+	skippers, err := rules.Skip.skippers()
+	if err != nil {
+		return err
+	}
+
+	routeCosts := make([]RouteCost, len(rules.RouteCosts))
+	copy(routeCosts, rules.RouteCosts)
+	sort.SliceStable(routeCosts, func(i, j int) bool {
+		return len(routeCosts[i].PathPrefix) > len(routeCosts[j].PathPrefix)
+	})
+
+	m.rules.Store(&ruleSet{
+		Rules:          rules,
+		allowlist:      allowlist,
+		denylist:       denylist,
+		trustedProxies: trustedProxies,
+		routeCosts:     routeCosts,
+		skippers:       skippers,
+	})
+	return nil
+}
+
+// SetUsageRecorder faz o middleware registrar, por dia, quantas requisições de
+// cada token foram permitidas e rejeitadas. Deve ser chamado antes de servir
+// requisições.
+func (m *RateLimiterMiddleware) SetUsageRecorder(recorder *usage.Recorder) {
+	m.usage = recorder
+}
+
+// SetFoldUnknownTokens faz o relatório de uso somar em UnknownTokensKey os
+// tokens sem plano nem política dinâmica, em vez de dar uma linha a cada
+// valor de API_KEY. Limita o hash do dia quando qualquer cliente pode
+// inventar tokens. Deve ser chamado antes de servir requisições.
+func (m *RateLimiterMiddleware) SetFoldUnknownTokens(fold bool) {
+	m.foldUnknownTokens = fold
+}
+
+// SetPolicyStore faz o middleware consultar limites dinâmicos por chave antes
+// das regras estáticas. Deve ser chamado antes de servir requisições.
+func (m *RateLimiterMiddleware) SetPolicyStore(store policy.Store) {
+	m.policies = store
+}
+
+// Decision é o resultado de aplicar as regras do middleware a uma requisição.
+// Result só é preenchido quando o limiter foi consultado, ou seja, fora das
+// listas de IPs. Em rotas cobradas pela resposta Cost é o que foi reservado na
+// admissão, acertado depois por Admission.Finish. Quota só é preenchido quando
+// a chave tem cota de longo prazo; uma cota esgotada resulta em Status 403.
+type Decision struct {
+	Status int
+	Key    string
+	Cost   int
+	Result limiter.Result
+	Quota  *quota.Usage
+
+	route     *RouteCost
+	windows   []limiter.Window
+	blockTime time.Duration
+	quota     quota.Quota
+	// concurrency é o limite de requisições simultâneas que barrou a
+	// requisição; release devolve a vaga ocupada por uma liberada.
+	concurrency int
+	release     func()
+	// queued é quanto tempo a requisição esperou na fila.
+	queued time.Duration
+	// rule diz de onde vieram os limites: ip, token, token_plan, policy,
+	// allowlist ou denylist.
+	rule string
+	// oversized indica que o custo passa do limite de uma das janelas: a
+	// requisição nunca seria liberada, então não há RetryAfter.
+	oversized bool
+}
+
+// RetryAfter é quanto o cliente deve esperar antes de tentar de novo: até a
+// renovação da cota, quando foi ela que barrou a requisição, ou o RetryAfter
+// do limiter.
+func (d Decision) RetryAfter() time.Duration {
+	if d.Quota != nil && !d.Quota.Allowed {
+		return time.Until(d.Quota.ResetAt)
+	}
+	return d.Result.RetryAfter
 }
 
 func (m *RateLimiterMiddleware) Handle(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token := r.Header.Get("API_KEY")
-		ip := realIP(r)
-
-		if token != "" {
-			if !m.limiter.IsAllowed("token:"+token, m.tokenLimit, m.ipDuration, m.tokenBlockTime) {
-				w.WriteHeader(http.StatusTooManyRequests)
-				_, err := w.Write([]byte("you have reached the maximum number of requests or actions allowed within a certain time frame"))
-				if err != nil {
-					return
-				}
-				return
+		admission, ok := m.Admit(w, r)
+		if !ok {
+			return
+		}
+		// Se o handler entrar em pânico a vaga de concorrência ainda volta.
+		defer admission.Release()
+		if !admission.NeedsResponse() {
+			next.ServeHTTP(w, admission.Request)
+			admission.Finish(0, 0)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, admission.Request)
+		admission.Finish(recorder.status, recorder.bytes)
+	})
+}
+
+// Admission é uma requisição liberada por Admit. O handler deve receber
+// Request, que carrega o span do middleware, e Finish deve ser chamado depois
+// dele.
+type Admission struct {
+	Request  *http.Request
+	Decision Decision
+
+	m    *RateLimiterMiddleware
+	span trace.Span
+}
+
+// Admit é a parte de Handle que vem antes do handler, para adaptadores de
+// frameworks que não usam http.Handler. Escreve os cabeçalhos de limite em w
+// e, se a requisição foi barrada, também a resposta de rejeição, devolvendo
+// falso. Quando devolve verdadeiro o handler deve ser chamado e, em seguida,
+// Admission.Finish.
+//
+// Com Rules.Queue, Admit pode bloquear até a janela da chave liberar ou o
+// contexto de r ser cancelado.
+func (m *RateLimiterMiddleware) Admit(w http.ResponseWriter, r *http.Request) (*Admission, bool) {
+	ctx, span := startSpan(r)
+	r = r.WithContext(ctx)
+
+	decision := m.decideRequest(r, r.Context())
+	m.annotateSpan(span, decision)
+	SetRateLimitHeaders(w.Header(), decision.Result)
+	SetQuotaHeaders(w.Header(), decision.Quota)
+	SetConcurrencyHeaders(w.Header(), decision)
+
+	switch {
+	case decision.Status == http.StatusForbidden && decision.Quota == nil:
+		w.WriteHeader(http.StatusForbidden)
+		span.End()
+		return nil, false
+	case decision.Status == http.StatusForbidden, decision.Status == http.StatusTooManyRequests:
+		m.writeLimited(w, r, decision)
+		span.End()
+		return nil, false
+	}
+	r = stripBypass(r, m.rules.Load().Skip.bypassHeader())
+	if decision.route != nil && decision.route.countsBody(r) {
+		r = r.WithContext(r.Context())
+		r.Body = &countingBody{ReadCloser: r.Body}
+	}
+	return &Admission{Request: r, Decision: decision, m: m, span: span}, true
+}
+
+// Release devolve a vaga de concorrência da requisição, se houver. Finish já
+// a chama; adaptadores devem adiá-la com defer para que um pânico no handler
+// não prenda a vaga até a lease expirar. Chamadas repetidas não têm efeito.
+func (a *Admission) Release() {
+	if a.Decision.release != nil {
+		a.Decision.release()
+	}
+}
+
+// NeedsResponse indica se a rota é cobrada depois do handler (pela resposta
+// ou por um corpo de tamanho desconhecido), caso em que Finish precisa do
+// status e do tamanho reais.
+func (a *Admission) NeedsResponse() bool {
+	return a.Decision.route != nil
+}
+
+// Finish acerta a cobrança das rotas calculadas pela resposta, devolve a vaga
+// de concorrência e encerra o span do middleware. Fora dessas rotas status e
+// bytes são ignorados.
+func (a *Admission) Finish(status int, bytes int64) {
+	defer a.span.End()
+	a.Release()
+	if a.NeedsResponse() {
+		a.m.settle(a.Request.Context(), a.Decision, a.Decision.route.finalCost(a.Request, status, bytes))
+	}
+}
+
+// settle compara o custo da resposta com o reservado na admissão e cobra ou
+// devolve a diferença.
+func (m *RateLimiterMiddleware) settle(ctx context.Context, decision Decision, n int) {
+	switch diff := n - decision.Cost; {
+	case diff > 0:
+		m.charge(ctx, decision, diff)
+	case diff < 0:
+		m.refund(ctx, decision, -diff)
+	}
+}
+
+// refund devolve n unidades reservadas e não usadas às janelas e à cota da
+// chave.
+func (m *RateLimiterMiddleware) refund(ctx context.Context, decision Decision, n int) {
+	ctx = context.WithoutCancel(ctx)
+	if decision.Quota != nil {
+		if err := m.quotas.WithContext(ctx).Refund(decision.Key, decision.quota, n); err != nil {
+			log.Printf("Error refunding quota for %s: %v", decision.Key, err)
+		}
+	}
+	if err := limiter.RefundWindows(limiter.WithContext(m.limiter, ctx), decision.Key, n, decision.windows); err != nil {
+		log.Printf("Error refunding %s: %v", decision.Key, err)
+	}
+}
+
+// charge consome n unidades depois da resposta. Como a requisição já foi
+// servida, um custo maior que o restante esgota a janela em vez de ser
+// descartado, para que a próxima requisição seja bloqueada.
+func (m *RateLimiterMiddleware) charge(ctx context.Context, decision Decision, n int) {
+	if n <= 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if decision.Quota != nil {
+		m.consumeQuota(ctx, decision.Key, decision.quota, n)
+	}
+	l := limiter.WithContext(m.limiter, ctx)
+	result := limiter.CheckWindows(l, decision.Key, n, decision.windows, decision.blockTime)
+	if !result.Allowed && result.Remaining > 0 {
+		limiter.CheckWindows(l, decision.Key, result.Remaining, decision.windows, decision.blockTime)
+	}
+}
+
+// Decide aplica as listas de IPs e os limites por token ou por IP à requisição,
+// consumindo o custo da rota quando ela é permitida. Rotas cobradas pela
+// resposta consomem o custo da requisição, que nunca é acertado: Decide não
+// vê a resposta.
+//
+// Decide não ocupa vagas de concorrência, que só fazem sentido enquanto um
+// handler roda; elas são tomadas por Handle e Admit.
+func (m *RateLimiterMiddleware) Decide(r *http.Request) Decision {
+	return m.decideRequest(r, nil)
+}
+
+func (m *RateLimiterMiddleware) decideRequest(r *http.Request, wait context.Context) Decision {
+	rules := m.rules.Load()
+	r = rules.withClientIP(r)
+	ip := ClientIP(r)
+	// A denylist vem antes dos Skippers: um IP bloqueado não escapa por um
+	// caminho de health check ou por uma rede interna.
+	if containsIP(rules.denylist, ip) {
+		decision := Decision{Status: http.StatusForbidden, Key: "ip:" + ip, rule: "denylist"}
+		m.logDecision(decision)
+		return decision
+	}
+	if m.skip(rules, r) {
+		return Decision{Status: http.StatusOK, rule: "skip"}
+	}
+	// O contexto só carrega o trace: um cliente que desiste no meio da
+	// verificação não deve fazer o limiter falhar.
+	ctx := context.WithoutCancel(r.Context())
+
+	if containsIP(rules.allowlist, ip) {
+		decision := Decision{Status: http.StatusOK, Key: "ip:" + ip, rule: "allowlist"}
+		m.logDecision(decision)
+		return decision
+	}
+
+	key := m.requestKey(r)
+	route := rules.route(r)
+	if route == nil {
+		return m.decide(ctx, rules, key, 1, wait)
+	}
+	decision := m.decide(ctx, rules, key, route.cost(r), wait)
+	if route.postHoc() || route.countsBody(r) {
+		decision.route = route
+	}
+	return decision
+}
+
+// CheckKey aplica os limites a uma chave já montada. Chaves "token:<api key>"
+// usam os limites de token; as demais usam os de IP, salvo política dinâmica.
+func (m *RateLimiterMiddleware) CheckKey(key string) Decision {
+	return m.CheckKeyNContext(context.Background(), key, 1)
+}
+
+// CheckKeyN é CheckKey consumindo n unidades.
+func (m *RateLimiterMiddleware) CheckKeyN(key string, n int) Decision {
+	return m.CheckKeyNContext(context.Background(), key, n)
+}
+
+// CheckKeyContext é CheckKey com as chamadas ao storage presas a ctx: o
+// cancelamento e o tracing da chamada de origem chegam ao Redis.
+func (m *RateLimiterMiddleware) CheckKeyContext(ctx context.Context, key string) Decision {
+	return m.CheckKeyNContext(ctx, key, 1)
+}
+
+// CheckKeyNContext é CheckKeyContext consumindo n unidades.
+func (m *RateLimiterMiddleware) CheckKeyNContext(ctx context.Context, key string, n int) Decision {
+	return m.decide(ctx, m.rules.Load(), key, n, nil)
+}
+
+// decide aplica os limites à chave. wait só é passado na admissão feita por
+// Handle e Admit: é o contexto da requisição, que pode esperar na fila até
+// ele ser cancelado, e uma requisição liberada também ocupa uma vaga de
+// concorrência, devolvida por Admission.Finish.
+func (m *RateLimiterMiddleware) decide(ctx context.Context, rules *ruleSet, key string, cost int, wait context.Context) Decision {
+	limit, duration, blockTime := rules.IPLimit, rules.IPDuration, rules.IPBlockTime
+	extra, rule := rules.IPWindows, "ip"
+	if token, ok := strings.CutPrefix(key, "token:"); ok {
+		limit, blockTime, extra, rule = rules.TokenLimit, rules.TokenBlockTime, rules.TokenWindows, "token"
+		if plan, ok := rules.TokenPlans[token]; ok {
+			limit, duration, blockTime, extra, rule = plan.Limit, plan.Duration, plan.BlockTime, plan.Windows, "token_plan"
+		}
+	}
+	if p, ok := m.lookupPolicy(ctx, key); ok {
+		limit, duration, blockTime, rule = p.Limit, p.Duration, p.BlockTime, "policy"
+	}
+
+	windows := make([]limiter.Window, 0, 1+len(extra))
+	windows = append(windows, limiter.Window{Limit: limit, Duration: duration})
+	windows = append(windows, extra...)
+
+	decision := Decision{
+		Status:    http.StatusOK,
+		Key:       key,
+		Cost:      cost,
+		windows:   windows,
+		blockTime: blockTime,
+		quota:     rules.quotaFor(key),
+		rule:      rule,
+	}
+	// Um custo maior que o limite de alguma janela nunca cabe nela: rejeita
+	// sem consumir nada e sem RetryAfter, em vez de bloquear a chave e mandar
+	// o cliente tentar de novo em vão.
+	for _, w := range windows {
+		if w.Limit > 0 && cost > w.Limit {
+			decision.Status = http.StatusTooManyRequests
+			decision.Result = limiter.Result{Limit: w.Limit}
+			decision.oversized = true
+			break
+		}
+	}
+	// A vaga de concorrência vem antes do limiter e da cota: uma requisição
+	// barrada por ela não gasta unidades que nunca seriam usadas.
+	if wait != nil && decision.Status == http.StatusOK {
+		m.acquireSlot(ctx, rules, &decision)
+	}
+	if decision.Status == http.StatusOK {
+		l := limiter.WithContext(m.limiter, ctx)
+		check := func() limiter.Result {
+			return limiter.CheckWindows(l, key, cost, windows, blockTime)
+		}
+		_, peeks := l.(limiter.Peeker)
+		_, takes := l.(limiter.Taker)
+		if wait != nil && peeks && takes {
+			peek := func() limiter.Result {
+				result, _ := limiter.PeekWindows(l, key, cost, windows)
+				return result
+			}
+			take := func() limiter.Result {
+				result, _ := limiter.TakeWindows(l, key, cost, windows)
+				return result
 			}
+			decision.Result, decision.queued = m.await(wait, rules.queueDelayFor(key), rules.Queue.Size, peek, take, check)
 		} else {
-			if !m.limiter.IsAllowed("ip:"+ip, m.ipLimit, m.ipDuration, m.ipBlockTime) {
-				w.WriteHeader(http.StatusTooManyRequests)
-				_, err := w.Write([]byte("you have reached the maximum number of requests or actions allowed within a certain time frame"))
-				if err != nil {
-					return
-				}
-				return
+			decision.Result = check()
+		}
+		if decision.Result.Allowed {
+			m.applyQuota(ctx, &decision)
+		} else {
+			decision.Status = http.StatusTooManyRequests
+		}
+		if decision.Status != http.StatusOK && decision.release != nil {
+			decision.release()
+			decision.release = nil
+		}
+	}
+	m.recordUsage(ctx, rules, decision)
+	m.logDecision(decision)
+	return decision
+}
+
+// UnknownTokensKey é a chave do relatório de uso que soma os tokens sem plano
+// nem política dinâmica, com SetFoldUnknownTokens.
+const UnknownTokensKey = "token:*"
+
+// recordUsage só contabiliza tokens: IPs variam demais para um relatório útil.
+// Cada token tem a própria linha, salvo com SetFoldUnknownTokens, quando os
+// que não têm plano nem política dinâmica são somados em UnknownTokensKey.
+func (m *RateLimiterMiddleware) recordUsage(ctx context.Context, rules *ruleSet, decision Decision) {
+	token, ok := strings.CutPrefix(decision.Key, "token:")
+	if m.usage == nil || !ok {
+		return
+	}
+	key := decision.Key
+	if _, planned := rules.TokenPlans[token]; m.foldUnknownTokens && !planned && decision.rule != "policy" {
+		key = UnknownTokensKey
+	}
+	if err := m.usage.WithContext(ctx).Record(key, decision.Status == http.StatusOK); err != nil {
+		log.Printf("Error recording usage for %s: %v", decision.Key, err)
+	}
+}
+
+func (rules *ruleSet) route(r *http.Request) *RouteCost {
+	for i, route := range rules.routeCosts {
+		if route.Method != "" && !strings.EqualFold(route.Method, r.Method) {
+			continue
+		}
+		if !hasPathPrefix(r.URL.Path, route.PathPrefix) {
+			continue
+		}
+		return &rules.routeCosts[i]
+	}
+	return nil
+}
+
+func (route RouteCost) cost(r *http.Request) int {
+	if route.Func != nil {
+		return max(route.Func(r), 1)
+	}
+
+	cost := max(route.Cost, 1)
+	if route.QueryParam != "" {
+		if n, err := strconv.Atoi(r.URL.Query().Get(route.QueryParam)); err == nil && n > 0 {
+			cost = max(cost, n)
+		}
+	}
+	if n := bodyLength(r); route.BodyBytesPerUnit > 0 && n > 0 {
+		cost += int((n + route.BodyBytesPerUnit - 1) / route.BodyBytesPerUnit)
+	}
+	return cost
+}
+
+// hasPathPrefix compara prefixos por segmento: "/api" casa com "/api" e
+// "/api/users", mas não com "/apiary".
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func (route RouteCost) postHoc() bool {
+	return route.OnlyFailures || route.ResponseBytesPerUnit > 0 || route.ResponseFunc != nil
+}
+
+// countsBody indica se o corpo da requisição, sem Content-Length, precisa ser
+// contado enquanto o handler o lê para ser cobrado depois.
+func (route RouteCost) countsBody(r *http.Request) bool {
+	return route.Func == nil && route.BodyBytesPerUnit > 0 && r.ContentLength < 0 &&
+		r.Body != nil && r.Body != http.NoBody
+}
+
+// finalCost é o custo da requisição depois do handler.
+func (route RouteCost) finalCost(r *http.Request, status int, bytes int64) int {
+	if route.postHoc() {
+		return route.responseCost(r, status, bytes)
+	}
+	return route.cost(r)
+}
+
+// countingBody conta os bytes lidos do corpo pelo handler. O contador é
+// atômico porque o proxy reverso lê o corpo em outra goroutine.
+type countingBody struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n.Add(int64(n))
+	return n, err
+}
+
+// bodyLength é o Content-Length ou, se ele é desconhecido, quanto do corpo já
+// foi lido.
+func bodyLength(r *http.Request) int64 {
+	if body, ok := r.Body.(*countingBody); ok && r.ContentLength < 0 {
+		return body.n.Load()
+	}
+	return r.ContentLength
+}
+
+func (route RouteCost) responseCost(r *http.Request, status int, bytes int64) int {
+	if route.ResponseFunc != nil {
+		return max(route.ResponseFunc(r, status, bytes), 0)
+	}
+	if route.OnlyFailures && status < http.StatusBadRequest {
+		return 0
+	}
+
+	cost := route.cost(r)
+	if route.ResponseBytesPerUnit > 0 && bytes > 0 {
+		cost += int((bytes + route.ResponseBytesPerUnit - 1) / route.ResponseBytesPerUnit)
+	}
+	return cost
+}
+
+// responseRecorder guarda o status e o total de bytes escritos pelo handler.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rw *responseRecorder) WriteHeader(status int) {
+	if !rw.wroteHeader {
+		rw.status = status
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseRecorder) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap permite que http.ResponseController alcance Flush e afins.
+func (rw *responseRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// SetRateLimitHeaders escreve X-RateLimit-Limit, X-RateLimit-Remaining e, para
+// requisições rejeitadas, Retry-After em segundos.
+func SetRateLimitHeaders(h http.Header, result limiter.Result) {
+	if result.Limit <= 0 {
+		return
+	}
+	h.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	if !result.Allowed && result.RetryAfter > 0 {
+		h.Set("Retry-After", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+	}
+}
+
+func (m *RateLimiterMiddleware) lookupPolicy(ctx context.Context, key string) (policy.Policy, bool) {
+	if m.policies == nil {
+		return policy.Policy{}, false
+	}
+	return policy.WithContext(m.policies, ctx).Lookup(key)
+}
+
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not a valid IP or CIDR", entry)
 			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid IP or CIDR", entry)
 		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
 
-		next.ServeHTTP(w, r)
-	})
+func containsIP(nets []*net.IPNet, ip string) bool {
+	if len(nets) == 0 {
+		return false
+	}
+	parsed := net.ParseIP(strings.Trim(ip, "[]"))
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }
 
-func realIP(r *http.Request) string {
-	// X-Real-IP primeiro
-	ip := r.Header.Get("X-Real-IP")
-	if ip != "" {
-		return ip
+// requestKey identifica quem fez a requisição: o token de API_KEY, se houver,
+// ou o IP resolvido por ClientIP.
+func requestKey(r *http.Request) string {
+	if token := r.Header.Get("API_KEY"); token != "" {
+		return "token:" + token
 	}
+	return "ip:" + ClientIP(r)
+}
+
+// clientIPKey guarda no contexto da requisição o IP resolvido pelo middleware.
+type clientIPKey struct{}
 
-	// Tentar X-Forwarded-For
-	ip = r.Header.Get("X-Forwarded-For")
-	if ip != "" {
-		ips := strings.Split(ip, ",")
-		return strings.TrimSpace(ips[0])
+// ClientIP devolve o IP de quem fez a requisição. Dentro do middleware, em
+// Skippers e KeyExtractors, é o IP já resolvido a partir dos cabeçalhos de
+// proxy (ver Rules.TrustedProxies); fora dele é sempre o de RemoteAddr.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
 	}
+	return remoteIP(r)
+}
 
-	// Pegar IP direto da requisição
-	ip = r.RemoteAddr
-	if i := strings.LastIndex(ip, ":"); i != -1 {
-		return ip[:i]
+// withClientIP resolve o IP do cliente uma vez e o guarda no contexto, onde
+// ClientIP o encontra.
+func (rules *ruleSet) withClientIP(r *http.Request) *http.Request {
+	if _, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return r
 	}
+	return r.WithContext(context.WithValue(r.Context(), clientIPKey{}, rules.clientIP(r)))
+}
 
+// clientIP, com TrustedProxies, só confia nos cabeçalhos de proxy quando a
+// conexão vem de um deles. Vale X-Real-IP e, na falta dele, o endereço mais à
+// direita de X-Forwarded-For que não seja de um proxy confiável: os da
+// esquerda foram escritos pelo cliente e podem ser forjados. Sem
+// TrustedProxies vale forwardedIP.
+func (rules *ruleSet) clientIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if len(rules.trustedProxies) == 0 {
+		return forwardedIP(r, ip)
+	}
+	if !containsIP(rules.trustedProxies, ip) {
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !containsIP(rules.trustedProxies, hop) {
+			break
+		}
+	}
 	return ip
 }
+
+// forwardedIP é a leitura sem TrustedProxies: X-Real-IP ou o primeiro salto
+// do X-Forwarded-For, de qualquer origem, e RemoteAddr na falta deles.
+func forwardedIP(r *http.Request, remote string) string {
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		if first = strings.TrimSpace(first); first != "" {
+			return first
+		}
+	}
+	return remote
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
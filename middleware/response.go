@@ -0,0 +1,183 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-expert-rater-limit/quota"
+)
+
+const (
+	contentTypeProblem = "application/problem+json"
+	contentTypeJSON    = "application/json"
+	contentTypeHTML    = "text/html"
+	contentTypeText    = "text/plain"
+)
+
+// LimitedHandler escreve a resposta de uma requisição barrada pelo limite
+// (429) ou pela cota (403). Quando é chamado os cabeçalhos X-RateLimit-*,
+// X-Quota-* e Retry-After já estão definidos, mas o status ainda não foi
+// escrito.
+type LimitedHandler func(w http.ResponseWriter, r *http.Request, decision Decision)
+
+// Problem é o corpo da rejeição no formato de problem details (RFC 9457),
+// com o estado do limite como membros de extensão. Também é o dado passado
+// ao template HTML.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail"`
+	Instance string `json:"instance,omitempty"`
+	Limit    int    `json:"limit,omitempty"`
+	// Remaining não é omitido quando zero, que é o caso comum numa rejeição.
+	Remaining  int          `json:"remaining"`
+	RetryAfter int          `json:"retry_after,omitempty"`
+	Quota      *quota.Usage `json:"quota,omitempty"`
+	// ConcurrencyLimit só aparece quando a rejeição veio do limite de
+	// requisições simultâneas.
+	ConcurrencyLimit int `json:"concurrency_limit,omitempty"`
+}
+
+// NewProblem descreve a decisão como problem details. RetryAfter é dado em
+// segundos, arredondado para cima como no cabeçalho Retry-After.
+func NewProblem(r *http.Request, decision Decision) Problem {
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(decision.Status),
+		Status:    decision.Status,
+		Instance:  r.URL.Path,
+		Limit:     decision.Result.Limit,
+		Remaining: decision.Result.Remaining,
+		Quota:     decision.Quota,
+	}
+	switch {
+	case decision.oversized:
+		problem.Detail = fmt.Sprintf("this request costs %d units, more than the limit of %d allowed within a time frame",
+			decision.Cost, decision.Result.Limit)
+	case decision.concurrency > 0:
+		problem.Detail = "you have too many requests in progress, wait for one of them to finish"
+		problem.RetryAfter = 1
+		problem.ConcurrencyLimit = decision.concurrency
+	case decision.Quota != nil && !decision.Quota.Allowed:
+		problem.Detail = fmt.Sprintf("you have used your %s quota of %d requests, it resets at %s",
+			decision.Quota.Period, decision.Quota.Limit, decision.Quota.ResetAt.Format(time.RFC3339))
+		problem.RetryAfter = int(math.Ceil(time.Until(decision.Quota.ResetAt).Seconds()))
+	default:
+		problem.Detail = "you have reached the maximum number of requests or actions allowed within a certain time frame"
+		problem.RetryAfter = int(math.Ceil(decision.Result.RetryAfter.Seconds()))
+	}
+	return problem
+}
+
+var defaultLimitedTemplate = template.Must(template.New("limited").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Title}}</title>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Detail}}</p>
+{{- if gt .RetryAfter 0}}
+<p>Try again in {{.RetryAfter}} seconds.</p>
+{{- end}}
+</body>
+</html>
+`))
+
+// SetOnLimited troca a resposta padrão das rejeições por handler. Deve ser
+// chamado antes de servir requisições.
+func (m *RateLimiterMiddleware) SetOnLimited(handler LimitedHandler) {
+	m.onLimited = handler
+}
+
+// SetLimitedTemplate troca o template da página HTML servida aos navegadores.
+// O template recebe um Problem. Deve ser chamado antes de servir requisições.
+func (m *RateLimiterMiddleware) SetLimitedTemplate(tmpl *template.Template) {
+	m.limitedTemplate = tmpl
+}
+
+func (m *RateLimiterMiddleware) writeLimited(w http.ResponseWriter, r *http.Request, decision Decision) {
+	if m.onLimited != nil {
+		m.onLimited(w, r, decision)
+		return
+	}
+	tmpl := m.limitedTemplate
+	if tmpl == nil {
+		tmpl = defaultLimitedTemplate
+	}
+	WriteProblem(w, r, NewProblem(r, decision), tmpl)
+}
+
+// WriteProblem escreve problem no formato pedido pelo cabeçalho Accept:
+// problem details em JSON, a página de tmpl em HTML ou, para clientes que
+// aceitam qualquer coisa, só o texto de Detail.
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem Problem, tmpl *template.Template) {
+	w.Header().Add("Vary", "Accept")
+
+	var err error
+	switch negotiate(r.Header.Get("Accept"), contentTypeText, contentTypeProblem, contentTypeJSON, contentTypeHTML) {
+	case contentTypeProblem, contentTypeJSON:
+		w.Header().Set("Content-Type", contentTypeProblem)
+		w.WriteHeader(problem.Status)
+		err = json.NewEncoder(w).Encode(problem)
+	case contentTypeHTML:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(problem.Status)
+		err = tmpl.Execute(w, problem)
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(problem.Status)
+		_, err = w.Write([]byte(problem.Detail))
+	}
+	if err != nil {
+		log.Printf("Error writing rate limit response: %v", err)
+	}
+}
+
+// negotiate escolhe entre offers o tipo de maior qualidade em accept. Em caso
+// de empate vence o que aparece primeiro em accept; curingas (*/* e text/*)
+// ficam com o primeiro offer compatível. Sem Accept, ou sem nenhum tipo
+// aceitável, vale o primeiro offer.
+func negotiate(accept string, offers ...string) string {
+	best, bestQ := offers[0], 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		for _, offer := range offers {
+			if matchesMediaType(mediaType, offer) {
+				best, bestQ = offer, q
+				break
+			}
+		}
+	}
+	return best
+}
+
+func matchesMediaType(pattern, offer string) bool {
+	if pattern == "*/*" || pattern == offer {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(pattern, "/*")
+	return ok && strings.HasPrefix(offer, prefix+"/")
+}
@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBypassHeader é o cabeçalho lido por SkipRules quando BypassHeader
+// está vazio.
+const DefaultBypassHeader = "X-RateLimit-Bypass"
+
+// SkipRules lista o tráfego que passa direto pelo middleware. As regras são
+// avaliadas depois da denylist e antes da allowlist e de qualquer acesso ao
+// storage; basta uma casar para a requisição seguir sem limite, sem cabeçalhos
+// e sem log.
+type SkipRules struct {
+	// Paths são caminhos exatos, como "/healthz".
+	Paths []string
+	// Methods pula todos os métodos listados, como "OPTIONS".
+	Methods []string
+	// Preflight pula só as requisições de preflight de CORS.
+	Preflight bool
+	// Networks são IPs ou CIDRs internos, comparados com o mesmo IP da
+	// allowlist (ver ClientIP).
+	Networks []string
+	// BypassSecret ativa o cabeçalho de bypass assinado (ver SignBypass). O
+	// middleware remove o cabeçalho antes de chamar o handler, para que ele
+	// não chegue ao upstream.
+	BypassSecret string
+	// BypassHeader é o nome do cabeçalho; padrão DefaultBypassHeader.
+	BypassHeader string
+	// BypassMaxAge é a validade de uma assinatura; padrão 5 minutos.
+	BypassMaxAge time.Duration
+}
+
+func (s SkipRules) skippers() ([]Skipper, error) {
+	var skippers []Skipper
+	if len(s.Paths) > 0 {
+		skippers = append(skippers, SkipPaths(s.Paths...))
+	}
+	if len(s.Methods) > 0 {
+		skippers = append(skippers, SkipMethods(s.Methods...))
+	}
+	if s.Preflight {
+		skippers = append(skippers, SkipPreflight)
+	}
+	if len(s.Networks) > 0 {
+		skip, err := SkipNetworks(s.Networks...)
+		if err != nil {
+			return nil, err
+		}
+		skippers = append(skippers, skip)
+	}
+	if header := s.bypassHeader(); header != "" {
+		maxAge := s.BypassMaxAge
+		if maxAge <= 0 {
+			maxAge = 5 * time.Minute
+		}
+		skippers = append(skippers, SkipSignedBypass(header, []byte(s.BypassSecret), maxAge))
+	}
+	return skippers, nil
+}
+
+// bypassHeader devolve o nome do cabeçalho de bypass, ou "" se ele estiver
+// desativado.
+func (s SkipRules) bypassHeader() string {
+	switch {
+	case s.BypassSecret == "":
+		return ""
+	case s.BypassHeader == "":
+		return DefaultBypassHeader
+	}
+	return s.BypassHeader
+}
+
+// SkipPaths pula as requisições para os caminhos exatos informados.
+func SkipPaths(paths ...string) Skipper {
+	return func(r *http.Request) bool {
+		return slices.Contains(paths, r.URL.Path)
+	}
+}
+
+// SkipMethods pula as requisições com os métodos informados.
+func SkipMethods(methods ...string) Skipper {
+	return func(r *http.Request) bool {
+		return slices.ContainsFunc(methods, func(method string) bool {
+			return strings.EqualFold(method, r.Method)
+		})
+	}
+}
+
+// SkipPreflight pula os preflights de CORS: OPTIONS com Origin e
+// Access-Control-Request-Method. Outros OPTIONS continuam limitados.
+func SkipPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions &&
+		r.Header.Get("Origin") != "" &&
+		r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// SkipNetworks pula as requisições vindas dos IPs ou CIDRs informados.
+func SkipNetworks(entries ...string) (Skipper, error) {
+	nets, err := parseNets(entries)
+	if err != nil {
+		return nil, fmt.Errorf("skip networks: %w", err)
+	}
+	return func(r *http.Request) bool {
+		return containsIP(nets, ClientIP(r))
+	}, nil
+}
+
+// bypassClockSkew é quanto uma assinatura de bypass pode estar no futuro, para
+// tolerar relógios levemente adiantados sem aceitar valores pré-assinados.
+const bypassClockSkew = 30 * time.Second
+
+// SkipSignedBypass pula as requisições cujo cabeçalho header traz uma
+// assinatura de SignBypass para o mesmo método e caminho, feita com secret há
+// no máximo maxAge. Quem usa o Skipper fora de SkipRules deve remover o
+// cabeçalho antes de repassar a requisição ao upstream.
+func SkipSignedBypass(header string, secret []byte, maxAge time.Duration) Skipper {
+	return func(r *http.Request) bool {
+		value := r.Header.Get(header)
+		if value == "" {
+			return false
+		}
+		timestamp, signature, ok := strings.Cut(value, ".")
+		if !ok {
+			return false
+		}
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return false
+		}
+		age := time.Since(time.Unix(unix, 0))
+		if age > maxAge || age < -bypassClockSkew {
+			return false
+		}
+		given, err := hex.DecodeString(signature)
+		return err == nil && hmac.Equal(given, bypassMAC(secret, timestamp, r.Method, r.URL.Path))
+	}
+}
+
+// SignBypass gera o valor do cabeçalho de bypass para uma requisição com o
+// método e o caminho informados, no instante at:
+// "<unix>.<HMAC-SHA256 de "<unix>\n<método>\n<caminho>" em hexadecimal>".
+// A assinatura não vale para outras rotas.
+func SignBypass(secret []byte, method, path string, at time.Time) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	return timestamp + "." + hex.EncodeToString(bypassMAC(secret, timestamp, method, path))
+}
+
+func bypassMAC(secret []byte, timestamp, method, path string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp + "\n" + strings.ToUpper(method) + "\n" + path))
+	return mac.Sum(nil)
+}
+
+// stripBypass tira o cabeçalho de bypass da requisição repassada ao handler,
+// sem alterar os cabeçalhos da requisição original.
+func stripBypass(r *http.Request, header string) *http.Request {
+	if header == "" || r.Header.Get(header) == "" {
+		return r
+	}
+	stripped := r.WithContext(r.Context())
+	stripped.Header = r.Header.Clone()
+	stripped.Header.Del(header)
+	return stripped
+}
+
+// skip avalia primeiro os Skippers das opções e depois os de SkipRules.
+func (m *RateLimiterMiddleware) skip(rules *ruleSet, r *http.Request) bool {
+	for _, skip := range m.skippers {
+		if skip(r) {
+			return true
+		}
+	}
+	for _, skip := range rules.skippers {
+		if skip(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "go-expert-rater-limit/middleware"
+
+// startSpan continua o trace recebido nos cabeçalhos da requisição, se houver,
+// e abre o span do middleware. As chamadas ao storage feitas com o contexto
+// devolvido viram filhas dele.
+func startSpan(r *http.Request) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return otel.Tracer(tracerName).Start(ctx, "RateLimiterMiddleware.Handle",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		),
+	)
+}
+
+// annotateSpan registra a decisão no span com os mesmos campos do log; a chave
+// também aparece só como hash.
+func (m *RateLimiterMiddleware) annotateSpan(span trace.Span, decision Decision) {
+	keyType, _, _ := strings.Cut(decision.Key, ":")
+	span.SetAttributes(
+		attribute.String("ratelimit.key_type", keyType),
+		attribute.String("ratelimit.key_hash", m.hashKey(decision.Key)),
+		attribute.String("ratelimit.rule", decision.rule),
+		attribute.String("ratelimit.reason", decisionReason(decision)),
+		attribute.Int("ratelimit.status", decision.Status),
+		attribute.Int("ratelimit.cost", decision.Cost),
+	)
+	if decision.Result.Limit > 0 {
+		span.SetAttributes(
+			attribute.Int("ratelimit.limit", decision.Result.Limit),
+			attribute.String("ratelimit.window", decision.Result.Duration.String()),
+			attribute.Int("ratelimit.remaining", decision.Result.Remaining),
+		)
+	}
+	if decision.Result.RetryAfter > 0 {
+		span.SetAttributes(attribute.String("ratelimit.retry_after", decision.Result.RetryAfter.String()))
+	}
+	if decision.Quota != nil {
+		span.SetAttributes(attribute.Int("ratelimit.quota_remaining", decision.Quota.Remaining))
+	}
+	if decision.concurrency > 0 {
+		span.SetAttributes(attribute.Int("ratelimit.concurrency_limit", decision.concurrency))
+	}
+	if decision.queued > 0 {
+		span.SetAttributes(attribute.String("ratelimit.queued", decision.queued.String()))
+	}
+}
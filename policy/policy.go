@@ -0,0 +1,33 @@
+package policy
+
+import (
+	"context"
+	"time"
+)
+
+type Policy struct {
+	Limit     int
+	Duration  time.Duration
+	BlockTime time.Duration
+}
+
+// Store fornece limites por chave ("token:<api key>" ou "ip:<ip>"). Quando
+// não há política para a chave, ok é false e valem os limites estáticos.
+type Store interface {
+	Lookup(key string) (Policy, bool)
+}
+
+// ContextBinder é implementado pelos Stores que aceitam o contexto da
+// requisição, usado para cancelamento e tracing.
+type ContextBinder interface {
+	WithContext(ctx context.Context) Store
+}
+
+// WithContext liga ctx ao store quando ele implementa ContextBinder e o
+// devolve inalterado caso contrário.
+func WithContext(s Store, ctx context.Context) Store {
+	if binder, ok := s.(ContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return s
+}
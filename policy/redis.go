@@ -0,0 +1,240 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	keyPrefix      = "policy:"
+	changesChannel = "policy:changes"
+	// maxCacheEntries limita o cache de Lookup. Cada IP ou token consultado
+	// vira uma entrada, inclusive os sem política, então sem limite o cache
+	// cresceria com cada cliente novo.
+	maxCacheEntries = 10000
+	// minResubscribe e maxResubscribe limitam o backoff de Run entre
+	// tentativas de assinar as notificações.
+	minResubscribe = time.Second
+	maxResubscribe = 30 * time.Second
+)
+
+type RedisStore struct {
+	client   *redis.Client
+	cacheTTL time.Duration
+	ctx      context.Context
+	*cache
+}
+
+// cache é compartilhado entre o RedisStore e as cópias de WithContext.
+// generation avança a cada invalidação: uma leitura que começou antes dela
+// não grava o resultado, que pode ser anterior à alteração.
+type cache struct {
+	mu         sync.RWMutex
+	entries    map[string]cacheEntry
+	generation uint64
+}
+
+type cacheEntry struct {
+	policy    Policy
+	found     bool
+	expiresAt time.Time
+}
+
+// NewRedisStore guarda as políticas em hashes "policy:<key>" compartilhados
+// entre as instâncias. As leituras, inclusive as de chaves sem política,
+// ficam em cache por cacheTTL, até maxCacheEntries chaves; com Run
+// rodando, alterações feitas via Set/Delete invalidam o cache imediatamente
+// em todas as instâncias.
+func NewRedisStore(client *redis.Client, cacheTTL time.Duration) *RedisStore {
+	return &RedisStore{
+		client:   client,
+		cacheTTL: cacheTTL,
+		ctx:      context.Background(),
+		cache:    &cache{entries: make(map[string]cacheEntry)},
+	}
+}
+
+// WithContext devolve uma cópia, com o mesmo cache, que usa ctx nas chamadas
+// ao Redis, para que cancelamento e trace da requisição cheguem até elas.
+func (s *RedisStore) WithContext(ctx context.Context) Store {
+	return &RedisStore{client: s.client, cacheTTL: s.cacheTTL, ctx: ctx, cache: s.cache}
+}
+
+func (s *RedisStore) Lookup(key string) (Policy, bool) {
+	s.mu.RLock()
+	entry, ok := s.entries[key]
+	generation := s.generation
+	s.mu.RUnlock()
+	if ok && time.Now().Before(entry.expiresAt) {
+		return entry.policy, entry.found
+	}
+
+	policy, found, err := s.fetch(s.ctx, key)
+	if err != nil {
+		found = false
+	}
+
+	s.mu.Lock()
+	if s.generation == generation {
+		if _, cached := s.entries[key]; !cached && len(s.entries) >= maxCacheEntries {
+			s.evict()
+		}
+		s.entries[key] = cacheEntry{policy: policy, found: found, expiresAt: time.Now().Add(s.cacheTTL)}
+	}
+	s.mu.Unlock()
+
+	return policy, found
+}
+
+// evict remove as entradas expiradas e, se o cache ainda estiver acima de 90%
+// de maxCacheEntries, entradas quaisquer até chegar lá (a iteração de um map é
+// aleatória). A folga evita varrer o cache a cada chave nova. Deve ser chamado
+// com s.mu travado.
+func (s *RedisStore) evict() {
+	now := time.Now()
+	for key, entry := range s.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+	for key := range s.entries {
+		if len(s.entries) < maxCacheEntries*9/10 {
+			return
+		}
+		delete(s.entries, key)
+	}
+}
+
+func (s *RedisStore) Set(key string, policy Policy) error {
+	ctx := s.ctx
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, keyPrefix+key,
+			"limit", policy.Limit,
+			"duration", policy.Duration.String(),
+			"block_time", policy.BlockTime.String(),
+		)
+		pipe.Publish(ctx, changesChannel, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate(key)
+	return nil
+}
+
+func (s *RedisStore) Delete(key string) error {
+	ctx := s.ctx
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, keyPrefix+key)
+		pipe.Publish(ctx, changesChannel, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.invalidate(key)
+	return nil
+}
+
+// Run escuta as notificações de alteração até ctx ser cancelado, quando
+// devolve nil. Se a assinatura falhar ou cair, Run tenta de novo com backoff
+// exponencial entre minResubscribe e maxResubscribe; enquanto isso o cacheTTL
+// garante que as instâncias ainda convergem. Cada nova assinatura esvazia o
+// cache, porque notificações podem ter se perdido no intervalo.
+func (s *RedisStore) Run(ctx context.Context) error {
+	backoff := minResubscribe
+	for {
+		subscribed, err := s.listen(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if subscribed {
+			backoff = minResubscribe
+		}
+		log.Printf("Error listening for policy changes, retrying in %v: %v", backoff, err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, maxResubscribe)
+	}
+}
+
+// listen assina as notificações e invalida o cache até ctx ser cancelado ou a
+// assinatura cair. subscribed indica se a assinatura chegou a ser feita.
+func (s *RedisStore) listen(ctx context.Context) (subscribed bool, err error) {
+	sub := s.client.Subscribe(ctx, changesChannel)
+	defer sub.Close()
+
+	if _, err := sub.Receive(ctx); err != nil {
+		return false, err
+	}
+	s.invalidateAll()
+
+	messages := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return true, ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return true, errors.New("subscription closed")
+			}
+			s.invalidate(msg.Payload)
+		}
+	}
+}
+
+func (s *RedisStore) invalidate(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.generation++
+	s.mu.Unlock()
+}
+
+func (s *RedisStore) invalidateAll() {
+	s.mu.Lock()
+	clear(s.entries)
+	s.generation++
+	s.mu.Unlock()
+}
+
+func (s *RedisStore) fetch(ctx context.Context, key string) (Policy, bool, error) {
+	values, err := s.client.HGetAll(ctx, keyPrefix+key).Result()
+	if err != nil {
+		return Policy{}, false, err
+	}
+	if len(values) == 0 {
+		return Policy{}, false, nil
+	}
+
+	limit, err := strconv.Atoi(values["limit"])
+	if err != nil {
+		return Policy{}, false, fmt.Errorf("policy %s: invalid limit %q", key, values["limit"])
+	}
+	duration, err := time.ParseDuration(values["duration"])
+	if err != nil {
+		return Policy{}, false, fmt.Errorf("policy %s: invalid duration %q", key, values["duration"])
+	}
+	blockTime, err := time.ParseDuration(values["block_time"])
+	if err != nil {
+		return Policy{}, false, fmt.Errorf("policy %s: invalid block_time %q", key, values["block_time"])
+	}
+	if limit <= 0 || duration <= 0 {
+		return Policy{}, false, fmt.Errorf("policy %s: limit and duration must be greater than zero", key)
+	}
+
+	return Policy{Limit: limit, Duration: duration, BlockTime: blockTime}, true, nil
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+type route struct {
+	prefix string
+	proxy  *httputil.ReverseProxy
+}
+
+type ReverseProxy struct {
+	routes []route
+}
+
+// New cria um proxy reverso a partir de um mapa prefixo de caminho -> URL do
+// upstream. Cada requisição vai para o upstream do maior prefixo que casar,
+// mantendo o caminho original. Os prefixos casam por segmento: "/api" atende
+// "/api" e "/api/users", mas não "/apiary".
+func New(upstreams map[string]string) (*ReverseProxy, error) {
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("at least one upstream is required")
+	}
+
+	routes := make([]route, 0, len(upstreams))
+	for prefix, rawURL := range upstreams {
+		if !strings.HasPrefix(prefix, "/") {
+			return nil, fmt.Errorf("upstream prefix %q must start with /", prefix)
+		}
+		target, err := ParseUpstream(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route{prefix: prefix, proxy: newSingleHostProxy(target)})
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+
+	return &ReverseProxy{routes: routes}, nil
+}
+
+func ParseUpstream(rawURL string) (*url.URL, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("upstream %q must be an absolute http or https URL", rawURL)
+	}
+	return target, nil
+}
+
+func (p *ReverseProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range p.routes {
+		if hasPathPrefix(r.URL.Path, route.prefix) {
+			route.proxy.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func hasPathPrefix(path, prefix string) bool {
+	return path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/")
+}
+
+func newSingleHostProxy(target *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(target)
+			// Rewrite remove o X-Forwarded-For recebido; ele é copiado para que
+			// SetXForwarded acrescente o IP do cliente à cadeia existente.
+			r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+			r.SetXForwarded()
+			otel.GetTextMapPropagator().Inject(r.In.Context(), propagation.HeaderCarrier(r.Out.Header))
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Upstream %s unavailable: %v", target.Host, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
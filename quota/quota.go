@@ -0,0 +1,165 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+// Period é o ciclo de renovação de uma cota, alinhado ao calendário.
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+var ErrCounterUnsupported = errors.New("quota: storage does not implement storage.Counter")
+
+// Quota é um limite de longo prazo que zera no início de cada dia ou mês no
+// fuso Location (UTC quando nil). Limit 0 desativa a cota.
+type Quota struct {
+	Limit    int
+	Period   Period
+	Location *time.Location
+}
+
+func (q Quota) Enabled() bool {
+	return q.Limit > 0
+}
+
+// Bounds devolve o início e o fim do período que contém t.
+func (q Quota) Bounds(t time.Time) (start, end time.Time) {
+	loc := q.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	t = t.In(loc)
+
+	switch q.Period {
+	case Daily:
+		start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 0, 1)
+	default:
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		end = start.AddDate(0, 1, 0)
+	}
+	return start, end
+}
+
+// Usage é o consumo de uma chave no período que contém o instante consultado.
+type Usage struct {
+	Key       string    `json:"key"`
+	Period    Period    `json:"period"`
+	Limit     int       `json:"limit"`
+	Used      int       `json:"used"`
+	Remaining int       `json:"remaining"`
+	Start     time.Time `json:"period_start"`
+	ResetAt   time.Time `json:"reset_at"`
+	// Allowed indica se o último Consume coube na cota.
+	Allowed bool `json:"-"`
+}
+
+// Tracker mantém os contadores das cotas no storage compartilhado, um por
+// chave e período, de modo que sobrevivem a reinícios e são vistos por todas
+// as instâncias. Os contadores são guardados até o fim do período seguinte
+// para que o consumo do período anterior ainda possa ser consultado.
+type Tracker struct {
+	storage storage.Storage
+}
+
+func NewTracker(storage storage.Storage) *Tracker {
+	return &Tracker{storage: storage}
+}
+
+// WithContext devolve um Tracker cujas chamadas ao storage usam ctx.
+func (t *Tracker) WithContext(ctx context.Context) *Tracker {
+	return &Tracker{storage: storage.WithContext(t.storage, ctx)}
+}
+
+// Consume soma n unidades ao período atual da chave. Se a soma passar do
+// limite as unidades são devolvidas e Usage.Allowed fica falso. Com n igual a
+// 0 apenas informa o consumo, sem gravar.
+func (t *Tracker) Consume(key string, q Quota, n int) (Usage, error) {
+	now := time.Now()
+	if n == 0 {
+		usage, err := t.Usage(key, q, now)
+		usage.Allowed = usage.Remaining > 0
+		return usage, err
+	}
+
+	counter, ok := t.storage.(storage.Counter)
+	if !ok {
+		return Usage{}, ErrCounterUnsupported
+	}
+
+	start, end := q.Bounds(now)
+	periodKey := counterKey(key, start)
+	retention := end.Sub(now) + end.Sub(start)
+
+	used, err := counter.IncrWithExpiration(periodKey, n, retention)
+	if err != nil {
+		return Usage{}, err
+	}
+	allowed := used <= q.Limit
+	if !allowed {
+		if _, err := counter.IncrWithExpiration(periodKey, -n, retention); err != nil {
+			return Usage{}, err
+		}
+		used -= n
+	}
+
+	usage := newUsage(key, q, used, start, end)
+	usage.Allowed = allowed
+	return usage, nil
+}
+
+// Refund devolve n unidades ao período atual da chave, sem deixar o contador
+// negativo.
+func (t *Tracker) Refund(key string, q Quota, n int) error {
+	counter, ok := t.storage.(storage.Counter)
+	if !ok {
+		return ErrCounterUnsupported
+	}
+
+	now := time.Now()
+	start, end := q.Bounds(now)
+	periodKey := counterKey(key, start)
+	retention := end.Sub(now) + end.Sub(start)
+
+	used, err := counter.IncrWithExpiration(periodKey, -n, retention)
+	if err != nil || used >= 0 {
+		return err
+	}
+	_, err = counter.IncrWithExpiration(periodKey, -used, retention)
+	return err
+}
+
+// Usage consulta o consumo da chave no período que contém at.
+func (t *Tracker) Usage(key string, q Quota, at time.Time) (Usage, error) {
+	start, end := q.Bounds(at)
+	used, err := t.storage.Get(counterKey(key, start))
+	if err != nil {
+		return Usage{}, err
+	}
+	return newUsage(key, q, used, start, end), nil
+}
+
+func newUsage(key string, q Quota, used int, start, end time.Time) Usage {
+	return Usage{
+		Key:       key,
+		Period:    q.Period,
+		Limit:     q.Limit,
+		Used:      used,
+		Remaining: max(q.Limit-used, 0),
+		Start:     start,
+		ResetAt:   end,
+	}
+}
+
+func counterKey(key string, start time.Time) string {
+	return fmt.Sprintf("quota:%s:%s", key, start.Format("2006-01-02"))
+}
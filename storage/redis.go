@@ -6,18 +6,95 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var incrWithExpirationScript = redis.NewScript(`
+local value = redis.call("INCRBY", KEYS[1], ARGV[1])
+if redis.call("PTTL", KEYS[1]) < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return value
+`)
+
+// As leases ficam num sorted set com a expiração como score, no relógio do
+// Redis para não depender do relógio de cada instância. Leases vencidas são
+// removidas antes de contar as vagas.
+var acquireLeaseScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], now + tonumber(ARGV[3]), ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return 1
+`)
+
+var renewLeaseScript = redis.NewScript(`
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call("ZADD", KEYS[1], "XX", now + tonumber(ARGV[2]), ARGV[1])
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+const tracerName = "go-expert-rater-limit/storage"
+
+// RedisStorage abre um span para cada chamada ao Redis. Os spans são filhos do
+// contexto ligado com WithContext ou, sem ele, raízes de um novo trace.
 type RedisStorage struct {
 	client *redis.Client
+	ctx    context.Context
 }
 
 func NewRedisStorage(client *redis.Client) *RedisStorage {
-	return &RedisStorage{client: client}
+	return &RedisStorage{client: client, ctx: context.Background()}
+}
+
+// WithContext devolve uma cópia que usa ctx nas chamadas ao Redis, para que
+// cancelamento e trace da requisição cheguem até elas.
+func (r *RedisStorage) WithContext(ctx context.Context) Storage {
+	return &RedisStorage{client: r.client, ctx: ctx}
+}
+
+func (r *RedisStorage) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "redis "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", operation),
+		),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
-func (r *RedisStorage) Get(key string) (int, error) {
-	ctx := context.Background()
+// Ping usa ctx em vez do contexto ligado, para respeitar o timeout de quem
+// verifica a prontidão.
+func (r *RedisStorage) Ping(ctx context.Context) (err error) {
+	ctx, span := r.start(ctx, "PING")
+	defer func() { end(span, err) }()
+
+	return r.client.Ping(ctx).Err()
+}
+
+func (r *RedisStorage) Get(key string) (n int, err error) {
+	ctx, span := r.start(r.ctx, "GET")
+	defer func() { end(span, err) }()
+
 	val, err := r.client.Get(ctx, key).Result()
 	if err == redis.Nil {
 		return 0, nil
@@ -28,23 +105,106 @@ func (r *RedisStorage) Get(key string) (int, error) {
 	return strconv.Atoi(val)
 }
 
-func (r *RedisStorage) Set(key string, value int, expiration time.Duration) error {
-	ctx := context.Background()
+func (r *RedisStorage) Set(key string, value int, expiration time.Duration) (err error) {
+	ctx, span := r.start(r.ctx, "SET")
+	defer func() { end(span, err) }()
+
 	return r.client.Set(ctx, key, value, expiration).Err()
 }
 
-func (r *RedisStorage) Incr(key string) error {
-	ctx := context.Background()
+func (r *RedisStorage) Incr(key string) (err error) {
+	ctx, span := r.start(r.ctx, "INCR")
+	defer func() { end(span, err) }()
+
 	return r.client.Incr(ctx, key).Err()
 }
 
+func (r *RedisStorage) IncrBy(key string, value int) (err error) {
+	ctx, span := r.start(r.ctx, "INCRBY")
+	defer func() { end(span, err) }()
+
+	return r.client.IncrBy(ctx, key, int64(value)).Err()
+}
+
+func (r *RedisStorage) IncrWithExpiration(key string, value int, expiration time.Duration) (n int, err error) {
+	ctx, span := r.start(r.ctx, "EVALSHA")
+	defer func() { end(span, err) }()
+
+	return incrWithExpirationScript.Run(ctx, r.client, []string{key}, value, expiration.Milliseconds()).Int()
+}
+
+func (r *RedisStorage) IncrField(key, field string, value int, expiration time.Duration) (err error) {
+	ctx, span := r.start(r.ctx, "HINCRBY")
+	defer func() { end(span, err) }()
+
+	pipe := r.client.TxPipeline()
+	pipe.HIncrBy(ctx, key, field, int64(value))
+	pipe.PExpire(ctx, key, expiration)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (r *RedisStorage) GetFields(key string) (fields map[string]int, err error) {
+	ctx, span := r.start(r.ctx, "HGETALL")
+	defer func() { end(span, err) }()
+
+	values, err := r.client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	fields = make(map[string]int, len(values))
+	for field, value := range values {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		fields[field] = n
+	}
+	return fields, nil
+}
+
+func (r *RedisStorage) AcquireLease(key, id string, limit int, lease time.Duration) (acquired bool, err error) {
+	ctx, span := r.start(r.ctx, "EVALSHA")
+	defer func() { end(span, err) }()
+
+	n, err := acquireLeaseScript.Run(ctx, r.client, []string{key}, id, limit, lease.Milliseconds()).Int()
+	return n == 1, err
+}
+
+func (r *RedisStorage) RenewLease(key, id string, lease time.Duration) (err error) {
+	ctx, span := r.start(r.ctx, "EVALSHA")
+	defer func() { end(span, err) }()
+
+	return renewLeaseScript.Run(ctx, r.client, []string{key}, id, lease.Milliseconds()).Err()
+}
+
+func (r *RedisStorage) ReleaseLease(key, id string) (err error) {
+	ctx, span := r.start(r.ctx, "ZREM")
+	defer func() { end(span, err) }()
+
+	return r.client.ZRem(ctx, key, id).Err()
+}
+
 func (r *RedisStorage) IsBlocked(key string) bool {
-	ctx := context.Background()
+	ctx, span := r.start(r.ctx, "GET")
 	val, err := r.client.Get(ctx, key+"_blocked").Result()
+	if err == redis.Nil {
+		err = nil
+	}
+	end(span, err)
 	return err == nil && val == "true"
 }
 
-func (r *RedisStorage) Block(key string, duration time.Duration) error {
-	ctx := context.Background()
+func (r *RedisStorage) Block(key string, duration time.Duration) (err error) {
+	ctx, span := r.start(r.ctx, "SET")
+	defer func() { end(span, err) }()
+
 	return r.client.Set(ctx, key+"_blocked", "true", duration).Err()
 }
+
+func (r *RedisStorage) BlockTTL(key string) (ttl time.Duration, err error) {
+	ctx, span := r.start(r.ctx, "PTTL")
+	defer func() { end(span, err) }()
+
+	return r.client.PTTL(ctx, key+"_blocked").Result()
+}
@@ -1,6 +1,9 @@
 package storage
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Storage interface {
 	Get(key string) (int, error)
@@ -9,3 +12,61 @@ type Storage interface {
 	IsBlocked(key string) bool
 	Block(key string, duration time.Duration) error
 }
+
+// BatchIncrementer é implementado pelos storages capazes de somar um delta em
+// uma única operação.
+type BatchIncrementer interface {
+	IncrBy(key string, value int) error
+}
+
+// BlockTTLReader é implementado pelos storages que sabem informar quanto
+// tempo falta para um bloqueio expirar.
+type BlockTTLReader interface {
+	BlockTTL(key string) (time.Duration, error)
+}
+
+// Counter é implementado pelos storages com incremento atômico que devolve o
+// novo valor. A expiração só é aplicada quando a chave ainda não tem TTL.
+type Counter interface {
+	IncrWithExpiration(key string, value int, expiration time.Duration) (int, error)
+}
+
+// FieldCounter é implementado pelos storages capazes de manter vários
+// contadores sob uma mesma chave, lidos de uma só vez. A expiração vale para a
+// chave inteira e é renovada a cada incremento.
+type FieldCounter interface {
+	IncrField(key, field string, value int, expiration time.Duration) error
+	GetFields(key string) (map[string]int, error)
+}
+
+// Semaphore é implementado pelos storages capazes de manter um semáforo
+// distribuído. Cada vaga é uma lease identificada por id que expira sozinha
+// depois de lease, de modo que um processo que morre sem liberar suas vagas
+// não as prende para sempre; quem segura uma vaga por mais tempo precisa
+// renová-la.
+type Semaphore interface {
+	AcquireLease(key, id string, limit int, lease time.Duration) (bool, error)
+	RenewLease(key, id string, lease time.Duration) error
+	ReleaseLease(key, id string) error
+}
+
+// Pinger é implementado pelos storages que sabem informar se o backend está
+// acessível, usado pela verificação de prontidão.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ContextBinder é implementado pelos storages que aceitam o contexto da
+// requisição, usado para cancelamento e tracing.
+type ContextBinder interface {
+	WithContext(ctx context.Context) Storage
+}
+
+// WithContext liga ctx ao storage quando ele implementa ContextBinder e o
+// devolve inalterado caso contrário.
+func WithContext(s Storage, ctx context.Context) Storage {
+	if binder, ok := s.(ContextBinder); ok {
+		return binder.WithContext(ctx)
+	}
+	return s
+}
@@ -0,0 +1,157 @@
+package adapters_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofiber/fiber/v2"
+	"github.com/labstack/echo/v4"
+
+	"go-expert-rater-limit/adapters/chilimiter"
+	"go-expert-rater-limit/adapters/echolimiter"
+	"go-expert-rater-limit/adapters/fiberlimiter"
+	"go-expert-rater-limit/adapters/ginlimiter"
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+// app monta, em cada framework, GET /ok respondendo 200 e GET /fail
+// respondendo 401, ambos atrás do rate limiter.
+type app func(m *middleware.RateLimiterMiddleware) func(req *http.Request) *http.Response
+
+func serveHTTP(h http.Handler) func(req *http.Request) *http.Response {
+	return func(req *http.Request) *http.Response {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Result()
+	}
+}
+
+var apps = map[string]app{
+	"chi": func(m *middleware.RateLimiterMiddleware) func(*http.Request) *http.Response {
+		r := chi.NewRouter()
+		r.Use(chilimiter.Middleware(m))
+		r.Get("/ok", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+		r.Get("/fail", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) })
+		return serveHTTP(r)
+	},
+	"gin": func(m *middleware.RateLimiterMiddleware) func(*http.Request) *http.Response {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.Use(ginlimiter.Middleware(m))
+		r.GET("/ok", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+		r.GET("/fail", func(c *gin.Context) { c.String(http.StatusUnauthorized, "no") })
+		return serveHTTP(r)
+	},
+	"echo": func(m *middleware.RateLimiterMiddleware) func(*http.Request) *http.Response {
+		e := echo.New()
+		e.Use(echolimiter.Middleware(m))
+		e.GET("/ok", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
+		e.GET("/fail", func(c echo.Context) error { return echo.ErrUnauthorized })
+		return serveHTTP(e)
+	},
+	"fiber": func(m *middleware.RateLimiterMiddleware) func(*http.Request) *http.Response {
+		f := fiber.New()
+		f.Use(fiberlimiter.Middleware(m))
+		f.Get("/ok", func(c *fiber.Ctx) error { return c.SendString("ok") })
+		f.Get("/fail", func(c *fiber.Ctx) error { return fiber.ErrUnauthorized })
+		return func(req *http.Request) *http.Response {
+			resp, err := f.Test(req)
+			if err != nil {
+				panic(err)
+			}
+			return resp
+		}
+	},
+}
+
+func newMiddleware(t *testing.T, opts ...middleware.Option) *middleware.RateLimiterMiddleware {
+	t.Helper()
+
+	m, err := middleware.New(limiter.NewRateLimiter(testutil.NewCounterStorage()), opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return m
+}
+
+func TestAdaptersRejectOverLimit(t *testing.T) {
+	for name, build := range apps {
+		t.Run(name, func(t *testing.T) {
+			serve := build(newMiddleware(t, middleware.WithIPLimit(2, time.Second)))
+
+			var resp *http.Response
+			for i := 0; i < 3; i++ {
+				resp = serve(httptest.NewRequest("GET", "/ok", nil))
+				if i < 2 && resp.StatusCode != http.StatusOK {
+					t.Fatalf("request %d: got %v want %v", i+1, resp.StatusCode, http.StatusOK)
+				}
+			}
+
+			if resp.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("got %v want %v", resp.StatusCode, http.StatusTooManyRequests)
+			}
+			if resp.Header.Get("X-RateLimit-Limit") != "2" || resp.Header.Get("Retry-After") == "" {
+				t.Errorf("expected rate limit headers, got %v", resp.Header)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if len(body) == 0 {
+				t.Errorf("expected a rejection body")
+			}
+		})
+	}
+}
+
+func TestAdaptersChargeFromResponse(t *testing.T) {
+	for name, build := range apps {
+		t.Run(name, func(t *testing.T) {
+			serve := build(newMiddleware(t,
+				middleware.WithIPLimit(1, time.Second),
+				middleware.WithRouteCosts(middleware.RouteCost{PathPrefix: "/", OnlyFailures: true}),
+			))
+
+			// Respostas de sucesso não consomem nada
+			for i := 0; i < 3; i++ {
+				if resp := serve(httptest.NewRequest("GET", "/ok", nil)); resp.StatusCode != http.StatusOK {
+					t.Fatalf("request %d: got %v want %v", i+1, resp.StatusCode, http.StatusOK)
+				}
+			}
+
+			// A primeira falha consome a única unidade
+			if resp := serve(httptest.NewRequest("GET", "/fail", nil)); resp.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("got %v want %v", resp.StatusCode, http.StatusUnauthorized)
+			}
+			if resp := serve(httptest.NewRequest("GET", "/ok", nil)); resp.StatusCode != http.StatusTooManyRequests {
+				t.Fatalf("got %v want %v", resp.StatusCode, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
+
+func TestEchoHandlesErrorsOnce(t *testing.T) {
+	e := echo.New()
+	handled := 0
+	e.HTTPErrorHandler = func(err error, c echo.Context) {
+		handled++
+		e.DefaultHTTPErrorHandler(err, c)
+	}
+	e.Use(echolimiter.Middleware(newMiddleware(t,
+		middleware.WithIPLimit(5, time.Second),
+		middleware.WithRouteCosts(middleware.RouteCost{PathPrefix: "/", OnlyFailures: true}),
+	)))
+	e.GET("/fail", func(c echo.Context) error { return echo.ErrUnauthorized })
+
+	resp := serveHTTP(e)(httptest.NewRequest("GET", "/fail", nil))
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("got %v want %v", resp.StatusCode, http.StatusUnauthorized)
+	}
+	if handled != 1 {
+		t.Errorf("expected the error to be handled once, got %d", handled)
+	}
+}
@@ -0,0 +1,43 @@
+package admin_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-expert-rater-limit/admin"
+)
+
+func TestRequireToken(t *testing.T) {
+	tests := []struct {
+		name           string
+		token          string
+		authorization  string
+		expectedStatus int
+	}{
+		{"Valid token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"Wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"Missing header", "s3cret", "", http.StatusUnauthorized},
+		{"Not a bearer token", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"No token configured", "", "Bearer ", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := admin.RequireToken(tt.token, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/admin/quota", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("got %v want %v", rr.Code, tt.expectedStatus)
+			}
+		})
+	}
+}
@@ -1,7 +1,12 @@
 package config_test
 
 import (
+	"context"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -13,7 +18,10 @@ func TestConfig(t *testing.T) {
 		// Limpa variáveis de ambiente antes do teste
 		os.Clearenv()
 
-		cfg := config.Load()
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
 
 		// Verifica valores padrão
 		if cfg.RedisAddr != "localhost:6379" {
@@ -37,6 +45,9 @@ func TestConfig(t *testing.T) {
 		if cfg.ServerPort != "8080" {
 			t.Errorf("Expected ServerPort to be 8080, got %s", cfg.ServerPort)
 		}
+		if cfg.AdminPort != "8081" {
+			t.Errorf("Expected AdminPort to be 8081, got %s", cfg.AdminPort)
+		}
 	})
 
 	t.Run("should load values from environment variables", func(t *testing.T) {
@@ -56,7 +67,10 @@ func TestConfig(t *testing.T) {
 		}
 		defer os.Clearenv()
 
-		cfg := config.Load()
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
 
 		// Verifica se os valores foram carregados corretamente
 		if cfg.RedisAddr != "redis:7000" {
@@ -82,39 +96,662 @@ func TestConfig(t *testing.T) {
 		}
 	})
 
-	t.Run("should handle invalid duration format", func(t *testing.T) {
+	t.Run("should reject invalid duration format", func(t *testing.T) {
 		os.Clearenv()
 		os.Setenv("IP_DURATION", "invalid")
 		os.Setenv("IP_BLOCK_TIME", "invalid")
 		os.Setenv("TOKEN_BLOCK_TIME", "invalid")
 
-		cfg := config.Load()
+		cfg, err := config.Load()
 
-		// Deve usar valores padrão quando o formato é inválido
-		if cfg.IPDuration != time.Second {
-			t.Errorf("Expected IPDuration to fallback to 1s, got %v", cfg.IPDuration)
+		if cfg != nil {
+			t.Errorf("Expected nil config, got %+v", cfg)
 		}
-		if cfg.IPBlockTime != 5*time.Minute {
-			t.Errorf("Expected IPBlockTime to fallback to 5m, got %v", cfg.IPBlockTime)
+		assertErrorContains(t, err, "IP_DURATION", "IP_BLOCK_TIME", "TOKEN_BLOCK_TIME")
+	})
+
+	t.Run("should reject invalid integer format", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("IP_LIMIT", "5O")
+		os.Setenv("TOKEN_LIMIT", "invalid")
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, `IP_LIMIT: "5O"`, `TOKEN_LIMIT: "invalid"`)
+	})
+
+	t.Run("should reject out of range values", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("IP_LIMIT", "0")
+		os.Setenv("TOKEN_LIMIT", "-1")
+		os.Setenv("IP_DURATION", "-1s")
+		os.Setenv("SERVER_PORT", "70000")
+		os.Setenv("LIMITER_STRATEGY", "fast")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "IP_LIMIT", "TOKEN_LIMIT", "IP_DURATION", "SERVER_PORT", "LIMITER_STRATEGY")
+	})
+
+	t.Run("should keep the admin port apart from the public one", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("ADMIN_PORT", "8080")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+		assertErrorContains(t, err, "ADMIN_PORT")
+	})
+
+	t.Run("should reject invalid log and tracing settings", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("LOG_LEVEL", "verbose")
+		os.Setenv("LOG_ALLOWED_SAMPLE_RATE", "1.5")
+		os.Setenv("TRACING_EXPORTER", "jaeger")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "LOG_LEVEL", "LOG_ALLOWED_SAMPLE_RATE", "TRACING_EXPORTER")
+	})
+
+	t.Run("should reject block time shorter than the window", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("IP_DURATION", "1m")
+		os.Setenv("IP_BLOCK_TIME", "30s")
+		os.Setenv("TOKEN_BLOCK_TIME", "10s")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "IP_BLOCK_TIME", "TOKEN_BLOCK_TIME")
+	})
+}
+
+func assertErrorContains(t *testing.T, err error, fragments ...string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatal("Expected an error, got nil")
+	}
+	for _, fragment := range fragments {
+		if !strings.Contains(err.Error(), fragment) {
+			t.Errorf("Expected error to mention %s, got:\n%v", fragment, err)
 		}
-		if cfg.TokenBlockTime != 6*time.Minute {
-			t.Errorf("Expected TokenBlockTime to fallback to 6m, got %v", cfg.TokenBlockTime)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+redis_addr: redis:7000
+ip:
+  limit: 20
+  duration: 2s
+  block_time: 1m
+token:
+  limit: 50
+plans:
+  premium:
+    limit: 100
+    duration: 1s
+    block_time: 2m
+tokens:
+  abc123: premium
+allowlist: [10.0.0.0/8]
+denylist: [203.0.113.7]
+`,
+		"config.toml": `
+redis_addr = "redis:7000"
+allowlist = ["10.0.0.0/8"]
+denylist = ["203.0.113.7"]
+
+[ip]
+limit = 20
+duration = "2s"
+block_time = "1m"
+
+[token]
+limit = 50
+
+[plans.premium]
+limit = 100
+duration = "1s"
+block_time = "2m"
+
+[tokens]
+abc123 = "premium"
+`,
+		"config.json": `{
+  "redis_addr": "redis:7000",
+  "ip": {"limit": 20, "duration": "2s", "block_time": "1m"},
+  "token": {"limit": 50},
+  "plans": {"premium": {"limit": 100, "duration": "1s", "block_time": "2m"}},
+  "tokens": {"abc123": "premium"},
+  "allowlist": ["10.0.0.0/8"],
+  "denylist": ["203.0.113.7"]
+}`,
+	}
+
+	for name, content := range files {
+		t.Run("should load "+name, func(t *testing.T) {
+			os.Clearenv()
+			os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, name, content))
+			defer os.Clearenv()
+
+			cfg, err := config.Load()
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if cfg.RedisAddr != "redis:7000" {
+				t.Errorf("Expected RedisAddr to be redis:7000, got %s", cfg.RedisAddr)
+			}
+			if cfg.IPLimit != 20 {
+				t.Errorf("Expected IPLimit to be 20, got %d", cfg.IPLimit)
+			}
+			if cfg.TokenLimit != 50 {
+				t.Errorf("Expected TokenLimit to be 50, got %d", cfg.TokenLimit)
+			}
+			if cfg.IPDuration != 2*time.Second {
+				t.Errorf("Expected IPDuration to be 2s, got %v", cfg.IPDuration)
+			}
+			if cfg.IPBlockTime != time.Minute {
+				t.Errorf("Expected IPBlockTime to be 1m, got %v", cfg.IPBlockTime)
+			}
+			if cfg.TokenBlockTime != 6*time.Minute {
+				t.Errorf("Expected TokenBlockTime to keep its default of 6m, got %v", cfg.TokenBlockTime)
+			}
+			want := config.Plan{Limit: 100, Duration: time.Second, BlockTime: 2 * time.Minute}
+			if !reflect.DeepEqual(cfg.Plans["premium"], want) {
+				t.Errorf("Expected premium plan to be %+v, got %+v", want, cfg.Plans["premium"])
+			}
+			if cfg.Tokens["abc123"] != "premium" {
+				t.Errorf("Expected token abc123 to use premium plan, got %q", cfg.Tokens["abc123"])
+			}
+			if len(cfg.Allowlist) != 1 || cfg.Allowlist[0] != "10.0.0.0/8" {
+				t.Errorf("Expected Allowlist to be [10.0.0.0/8], got %v", cfg.Allowlist)
+			}
+			if len(cfg.Denylist) != 1 || cfg.Denylist[0] != "203.0.113.7" {
+				t.Errorf("Expected Denylist to be [203.0.113.7], got %v", cfg.Denylist)
+			}
+		})
+	}
+
+	t.Run("should let environment variables override the file", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", files["config.yaml"]))
+		os.Setenv("IP_LIMIT", "30")
+		os.Setenv("DENYLIST", "198.51.100.1, 198.51.100.2")
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if cfg.IPLimit != 30 {
+			t.Errorf("Expected IPLimit to be 30, got %d", cfg.IPLimit)
+		}
+		if cfg.IPDuration != 2*time.Second {
+			t.Errorf("Expected IPDuration to come from the file, got %v", cfg.IPDuration)
+		}
+		if len(cfg.Denylist) != 2 || cfg.Denylist[1] != "198.51.100.2" {
+			t.Errorf("Expected Denylist from env, got %v", cfg.Denylist)
 		}
 	})
 
-	t.Run("should handle invalid integer format", func(t *testing.T) {
+	t.Run("should add UPSTREAM_URL as the root upstream", func(t *testing.T) {
 		os.Clearenv()
-		os.Setenv("IP_LIMIT", "invalid")
-		os.Setenv("TOKEN_LIMIT", "invalid")
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+upstreams:
+  /: http://web:8080
+  /api: http://api:8080
+`))
+		os.Setenv("UPSTREAM_URL", "http://other:9090")
+		defer os.Clearenv()
 
-		cfg := config.Load()
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
 
-		// Deve usar valores padrão quando o formato é inválido
-		if cfg.IPLimit != 5 {
-			t.Errorf("Expected IPLimit to fallback to 5, got %d", cfg.IPLimit)
+		if cfg.Upstreams["/"] != "http://other:9090" {
+			t.Errorf("Expected root upstream from env, got %q", cfg.Upstreams["/"])
 		}
-		if cfg.TokenLimit != 10 {
-			t.Errorf("Expected TokenLimit to fallback to 10, got %d", cfg.TokenLimit)
+		if cfg.Upstreams["/api"] != "http://api:8080" {
+			t.Errorf("Expected /api upstream from the file, got %q", cfg.Upstreams["/api"])
+		}
+	})
+
+	t.Run("should disable the write timeout in proxy mode", func(t *testing.T) {
+		os.Clearenv()
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cfg.WriteTimeout != 10*time.Second {
+			t.Errorf("Expected a 10s write timeout without upstreams, got %v", cfg.WriteTimeout)
+		}
+
+		os.Setenv("UPSTREAM_URL", "http://api:8080")
+		cfg, err = config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cfg.WriteTimeout != 0 {
+			t.Errorf("Expected no write timeout in proxy mode, got %v", cfg.WriteTimeout)
+		}
+
+		// Um valor explícito continua valendo, e o zero também é aceito sem upstream
+		os.Setenv("SERVER_WRITE_TIMEOUT", "30s")
+		if cfg, err = config.Load(); err != nil || cfg.WriteTimeout != 30*time.Second {
+			t.Errorf("Expected the explicit 30s write timeout, got %v (err %v)", cfg.WriteTimeout, err)
+		}
+		os.Unsetenv("UPSTREAM_URL")
+		os.Setenv("SERVER_WRITE_TIMEOUT", "0s")
+		if _, err = config.Load(); err != nil {
+			t.Errorf("Expected a zero write timeout to be valid, got %v", err)
+		}
+		os.Setenv("SERVER_WRITE_TIMEOUT", "-1s")
+		_, err = config.Load()
+		assertErrorContains(t, err, "SERVER_WRITE_TIMEOUT")
+	})
+
+	t.Run("should reject invalid upstreams", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("UPSTREAM_URL", "api:8080")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "upstreams./")
+	})
+
+	t.Run("should load multiple windows", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+ip:
+  limit: 10
+  duration: 1s
+  windows:
+    - limit: 500
+      duration: 1m
+plans:
+  pro:
+    limit: 10
+    duration: 1s
+    block_time: 1m
+    windows:
+      - limit: 50000
+        duration: 24h
+`))
+		os.Setenv("TOKEN_WINDOWS", "500/1m, 50000/24h")
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if want := []config.Window{{Limit: 500, Duration: time.Minute}}; !reflect.DeepEqual(cfg.IPWindows, want) {
+			t.Errorf("Expected IPWindows to be %+v, got %+v", want, cfg.IPWindows)
+		}
+		if want := []config.Window{{Limit: 500, Duration: time.Minute}, {Limit: 50000, Duration: 24 * time.Hour}}; !reflect.DeepEqual(cfg.TokenWindows, want) {
+			t.Errorf("Expected TokenWindows to be %+v, got %+v", want, cfg.TokenWindows)
+		}
+		if want := []config.Window{{Limit: 50000, Duration: 24 * time.Hour}}; !reflect.DeepEqual(cfg.Plans["pro"].Windows, want) {
+			t.Errorf("Expected pro plan windows to be %+v, got %+v", want, cfg.Plans["pro"].Windows)
+		}
+	})
+
+	t.Run("should reject invalid windows", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("IP_WINDOWS", "500/1m,500/1m,0/1h")
+		os.Setenv("TOKEN_WINDOWS", "500 per minute")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "IP_WINDOWS[1].duration", "IP_WINDOWS[2].limit", "TOKEN_WINDOWS")
+	})
+
+	t.Run("should load quotas", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+admin_token: s3cret
+token:
+  quota: {limit: 100000, period: monthly}
+plans:
+  pro:
+    limit: 10
+    duration: 1s
+    block_time: 1m
+    quota: {limit: 1000000, period: monthly, timezone: America/Sao_Paulo}
+`))
+		os.Setenv("IP_QUOTA", "5000/daily")
+		os.Setenv("QUOTA_TIMEZONE", "Europe/Lisbon")
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if want := (config.Quota{Limit: 5000, Period: "daily", Timezone: "Europe/Lisbon"}); cfg.IPQuota != want {
+			t.Errorf("Expected IPQuota to be %+v, got %+v", want, cfg.IPQuota)
+		}
+		if want := (config.Quota{Limit: 100000, Period: "monthly", Timezone: "Europe/Lisbon"}); cfg.TokenQuota != want {
+			t.Errorf("Expected TokenQuota to be %+v, got %+v", want, cfg.TokenQuota)
+		}
+		if want := (config.Quota{Limit: 1000000, Period: "monthly", Timezone: "America/Sao_Paulo"}); cfg.Plans["pro"].Quota != want {
+			t.Errorf("Expected pro plan quota to be %+v, got %+v", want, cfg.Plans["pro"].Quota)
+		}
+		if cfg.AdminToken != "s3cret" {
+			t.Errorf("Expected AdminToken from the file, got %q", cfg.AdminToken)
+		}
+	})
+
+	t.Run("should load usage reporting", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+usage_reporting: true
+usage_retention: 2160h
+`))
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !cfg.UsageReporting || cfg.UsageRetention != 90*24*time.Hour {
+			t.Errorf("Expected usage reporting for 90 days, got %v for %v", cfg.UsageReporting, cfg.UsageRetention)
+		}
+		if cfg.UsageFoldUnknownTokens {
+			t.Error("Expected a row per token by default")
+		}
+
+		os.Setenv("USAGE_FOLD_UNKNOWN_TOKENS", "true")
+		if cfg, err = config.Load(); err != nil || !cfg.UsageFoldUnknownTokens {
+			t.Errorf("Expected USAGE_FOLD_UNKNOWN_TOKENS to fold unknown tokens, got %v (err %v)", cfg.UsageFoldUnknownTokens, err)
+		}
+
+		os.Setenv("USAGE_RETENTION", "0s")
+		_, err = config.Load()
+		assertErrorContains(t, err, "USAGE_RETENTION")
+	})
+
+	t.Run("should load skip rules", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+skip:
+  paths: [/healthz, /readyz]
+  preflight: true
+  networks: [10.0.0.0/8]
+  bypass:
+    secret: s3cret
+    max_age: 1m
+`))
+		os.Setenv("SKIP_METHODS", "HEAD")
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		expected := config.Skip{
+			Paths:        []string{"/healthz", "/readyz"},
+			Methods:      []string{"HEAD"},
+			Preflight:    true,
+			Networks:     []string{"10.0.0.0/8"},
+			BypassSecret: "s3cret",
+			BypassHeader: "X-RateLimit-Bypass",
+			BypassMaxAge: time.Minute,
+		}
+		if !reflect.DeepEqual(cfg.Skip, expected) {
+			t.Errorf("Expected skip rules %+v, got %+v", expected, cfg.Skip)
+		}
+
+		os.Setenv("SKIP_NETWORKS", "internal")
+		os.Setenv("SKIP_PATHS", "healthz")
+		os.Setenv("BYPASS_MAX_AGE", "0s")
+		_, err = config.Load()
+		assertErrorContains(t, err, "SKIP_NETWORKS", "SKIP_PATHS", "BYPASS_MAX_AGE")
+	})
+
+	t.Run("should load concurrency limits", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+ip:
+  concurrency: 4
+concurrency_lease: 10s
+plans:
+  pro:
+    limit: 100
+    duration: 1s
+    block_time: 1m
+    concurrency: 20
+`))
+		os.Setenv("TOKEN_CONCURRENCY", "8")
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cfg.IPConcurrency != 4 || cfg.TokenConcurrency != 8 || cfg.ConcurrencyLease != 10*time.Second {
+			t.Errorf("Expected concurrency 4/8 with a 10s lease, got %d/%d with %v", cfg.IPConcurrency, cfg.TokenConcurrency, cfg.ConcurrencyLease)
+		}
+		if cfg.Plans["pro"].Concurrency != 20 {
+			t.Errorf("Expected plan concurrency 20, got %d", cfg.Plans["pro"].Concurrency)
+		}
+
+		os.Setenv("IP_CONCURRENCY", "-1")
+		os.Setenv("CONCURRENCY_LEASE", "0s")
+		_, err = config.Load()
+		assertErrorContains(t, err, "IP_CONCURRENCY", "CONCURRENCY_LEASE")
+	})
+
+	t.Run("should load the request queue", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+queue:
+  size: 50
+plans:
+  internal:
+    limit: 100
+    duration: 1s
+    block_time: 1s
+    queue_max_delay: 2s
+`))
+		os.Setenv("QUEUE_MAX_DELAY", "500ms")
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if cfg.QueueMaxDelay != 500*time.Millisecond || cfg.QueueSize != 50 {
+			t.Errorf("Expected a 500ms queue of 50, got %v of %d", cfg.QueueMaxDelay, cfg.QueueSize)
+		}
+		if cfg.Plans["internal"].QueueMaxDelay != 2*time.Second {
+			t.Errorf("Expected plan queue delay 2s, got %v", cfg.Plans["internal"].QueueMaxDelay)
+		}
+
+		os.Setenv("QUEUE_MAX_DELAY", "-1s")
+		os.Setenv("QUEUE_SIZE", "0")
+		_, err = config.Load()
+		assertErrorContains(t, err, "QUEUE_MAX_DELAY", "QUEUE_SIZE")
+
+		// A espera precisa caber no prazo de escrita do servidor
+		os.Setenv("QUEUE_MAX_DELAY", "10s")
+		os.Setenv("QUEUE_SIZE", "50")
+		os.Setenv("SERVER_WRITE_TIMEOUT", "2s")
+		_, err = config.Load()
+		assertErrorContains(t, err, "QUEUE_MAX_DELAY", "plans.internal.queue_max_delay")
+	})
+
+	t.Run("should reject invalid quotas", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("IP_QUOTA", "5000/weekly")
+		os.Setenv("TOKEN_QUOTA", "lots")
+		os.Setenv("QUOTA_TIMEZONE", "Mars/Olympus_Mons")
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "IP_QUOTA.period", "IP_QUOTA.timezone", "TOKEN_QUOTA")
+	})
+
+	t.Run("should load route costs", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+routes:
+  - method: POST
+    path: /export
+    cost: 10
+  - path: /search
+    query_param: pages
+  - path: /upload
+    body_bytes_per_unit: 1048576
+`))
+		defer os.Clearenv()
+
+		cfg, err := config.Load()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		want := []config.Route{
+			{Method: "POST", Path: "/export", Cost: 10},
+			{Path: "/search", QueryParam: "pages"},
+			{Path: "/upload", BodyBytesPerUnit: 1048576},
+		}
+		if len(cfg.Routes) != len(want) {
+			t.Fatalf("Expected %d routes, got %+v", len(want), cfg.Routes)
+		}
+		for i := range want {
+			if cfg.Routes[i] != want[i] {
+				t.Errorf("Expected route %d to be %+v, got %+v", i, want[i], cfg.Routes[i])
+			}
+		}
+	})
+
+	t.Run("should reject invalid files", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+plans:
+  basic:
+    limit: 0
+    duration: 1s
+    block_time: 1m
+tokens:
+  abc123: gold
+allowlist: [not-an-ip]
+`))
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "plans.basic.limit", `unknown plan "gold"`, `ALLOWLIST: "not-an-ip"`)
+	})
+
+	t.Run("should reject unknown keys", func(t *testing.T) {
+		files := map[string]string{
+			"config.yaml": "ip:\n  limt: 5\n",
+			"config.toml": "[ip]\nlimt = 5\n",
+			"config.json": `{"ip": {"limt": 5}}`,
+		}
+		for name, content := range files {
+			os.Clearenv()
+			os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, name, content))
+
+			_, err := config.Load()
+
+			assertErrorContains(t, err, "limt")
+		}
+		os.Clearenv()
+	})
+
+	t.Run("should reject a token duration", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.yaml", `
+token:
+  limit: 10
+  duration: 1m
+`))
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "token.duration")
+	})
+
+	t.Run("should reject unsupported extensions", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", writeConfigFile(t, "config.ini", "ip_limit=5"))
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "unsupported file extension")
+	})
+
+	t.Run("should report missing files", func(t *testing.T) {
+		os.Clearenv()
+		os.Setenv("RATE_LIMIT_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+		defer os.Clearenv()
+
+		_, err := config.Load()
+
+		assertErrorContains(t, err, "RATE_LIMIT_CONFIG")
+	})
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	return path
+}
+
+func TestWatch(t *testing.T) {
+	path := writeConfigFile(t, "config.yaml", "ip:\n  limit: 5\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reloads := make(chan struct{}, 10)
+	go config.Watch(ctx, path, 10*time.Millisecond, func() {
+		reloads <- struct{}{}
+	})
+
+	waitReload := func(t *testing.T) {
+		t.Helper()
+		select {
+		case <-reloads:
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected configuration to be reloaded")
+		}
+	}
+
+	t.Run("should reload when the file changes", func(t *testing.T) {
+		time.Sleep(20 * time.Millisecond)
+		if err := os.WriteFile(path, []byte("ip:\n  limit: 50\n"), 0o600); err != nil {
+			t.Fatalf("Error writing config file: %v", err)
+		}
+		waitReload(t)
+	})
+
+	t.Run("should reload on SIGHUP", func(t *testing.T) {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatalf("Error sending SIGHUP: %v", err)
 		}
+		waitReload(t)
 	})
 }
@@ -0,0 +1,312 @@
+package extauthz_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	ratelimitv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	rlsv3 "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go-expert-rater-limit/extauthz"
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/quota"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+// newMiddleware confia no proxy de 172.17.0.1, de onde vêm as verificações
+// de forward auth.
+func newMiddleware() *middleware.RateLimiterMiddleware {
+	m, _ := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+		IPLimit:        2,
+		TokenLimit:     3,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: 2 * time.Minute,
+		TrustedProxies: []string{"172.17.0.1"},
+	})
+	return m
+}
+
+func TestHTTPHandler(t *testing.T) {
+	handler := extauthz.NewHTTPHandler(newMiddleware())
+
+	check := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/ext_authz/api/users", nil)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := check()
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Remaining"))
+
+	check()
+	rr = check()
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+}
+
+func TestHTTPHandlerStripsPrefix(t *testing.T) {
+	m, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+		IPLimit:        3,
+		TokenLimit:     3,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		RouteCosts:     []middleware.RouteCost{{Method: "POST", PathPrefix: "/export", Cost: 3}},
+		Skip:           middleware.SkipRules{Paths: []string{"/healthz"}},
+	})
+	assert.NoError(t, err)
+	handler := extauthz.NewHTTPHandler(m)
+
+	check := func(method, path, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// O custo da rota vale para o caminho sem o prefixo do ext_authz
+	rr := check("POST", "/ext_authz/export", "10.0.0.1")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, check("GET", "/ext_authz/healthz", "10.0.0.2").Code)
+	}
+}
+
+func TestForwardAuthHandler(t *testing.T) {
+	handler := extauthz.NewForwardAuthHandler(newMiddleware())
+
+	tests := []struct {
+		name           string
+		headers        map[string]string
+		executeCount   int
+		expectedStatus int
+	}{
+		{
+			name: "Traefik ForwardAuth headers",
+			headers: map[string]string{
+				"X-Forwarded-Method": "POST",
+				"X-Forwarded-Uri":    "/api/orders?id=1",
+				"X-Forwarded-Host":   "api.example.com",
+				"X-Forwarded-For":    "10.1.0.1",
+			},
+			executeCount:   3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "nginx auth_request headers",
+			headers: map[string]string{
+				"X-Original-Method":      "GET",
+				"X-Original-URI":         "/index.html",
+				"X-Original-Remote-Addr": "10.1.0.2",
+			},
+			executeCount:   2,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "nginx auth_request over the limit",
+			headers: map[string]string{
+				"X-Original-URI":         "/index.html",
+				"X-Original-Remote-Addr": "10.1.0.2",
+			},
+			executeCount:   1,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "token from the original request",
+			headers: map[string]string{
+				"X-Forwarded-Uri": "/",
+				"X-Forwarded-For": "10.1.0.3",
+				"API_KEY":         "forward-token",
+			},
+			executeCount:   3,
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rr *httptest.ResponseRecorder
+			for i := 0; i < tt.executeCount; i++ {
+				req := httptest.NewRequest("GET", "/check", nil)
+				req.RemoteAddr = "172.17.0.1:40000"
+				for k, v := range tt.headers {
+					req.Header.Set(k, v)
+				}
+				rr = httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+			}
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.NotEmpty(t, rr.Header().Get("X-RateLimit-Limit"))
+		})
+	}
+}
+
+// Sem TrustedProxies cada cliente do Traefik continua com a própria chave.
+func TestForwardAuthHandlerDefaultRules(t *testing.T) {
+	m := middleware.NewRateLimiterMiddleware(limiter.NewRateLimiter(testutil.NewMockStorage()), 2, 3, time.Second, time.Minute, time.Minute)
+	handler := extauthz.NewForwardAuthHandler(m)
+
+	check := func(client string) int {
+		req := httptest.NewRequest("GET", "/check", nil)
+		req.RemoteAddr = "172.17.0.1:4321"
+		req.Header.Set("X-Forwarded-Uri", "/")
+		req.Header.Set("X-Forwarded-For", client)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, client := range []string{"10.2.0.1", "10.2.0.2"} {
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusOK, check(client), "client %s", client)
+		}
+	}
+	assert.Equal(t, http.StatusTooManyRequests, check("10.2.0.1"))
+}
+
+func descriptor(entries ...string) *ratelimitv3.RateLimitDescriptor {
+	d := &ratelimitv3.RateLimitDescriptor{}
+	for i := 0; i+1 < len(entries); i += 2 {
+		d.Entries = append(d.Entries, &ratelimitv3.RateLimitDescriptor_Entry{Key: entries[i], Value: entries[i+1]})
+	}
+	return d
+}
+
+func TestRateLimitService(t *testing.T) {
+	service := extauthz.NewRateLimitService(newMiddleware())
+	ctx := context.Background()
+
+	t.Run("uses token limits for api_key descriptors", func(t *testing.T) {
+		req := &rlsv3.RateLimitRequest{
+			Domain:      "edge",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("api_key", "abc")},
+		}
+
+		for i := 0; i < 3; i++ {
+			resp, err := service.ShouldRateLimit(ctx, req)
+			assert.NoError(t, err)
+			assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetOverallCode())
+		}
+
+		resp, err := service.ShouldRateLimit(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.GetOverallCode())
+
+		status := resp.GetStatuses()[0]
+		assert.Equal(t, uint32(3), status.GetCurrentLimit().GetRequestsPerUnit())
+		assert.Equal(t, rlsv3.RateLimitResponse_RateLimit_SECOND, status.GetCurrentLimit().GetUnit())
+		assert.Equal(t, 2*time.Minute, status.GetDurationUntilReset().AsDuration())
+	})
+
+	t.Run("rejects when any descriptor is over the limit", func(t *testing.T) {
+		req := &rlsv3.RateLimitRequest{
+			Domain: "edge",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{
+				descriptor("remote_address", "10.0.0.2"),
+				descriptor("path", "/export", "method", "POST"),
+			},
+			HitsAddend: 2,
+		}
+
+		resp, err := service.ShouldRateLimit(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetOverallCode())
+		assert.Len(t, resp.GetStatuses(), 2)
+
+		resp, err = service.ShouldRateLimit(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.GetOverallCode())
+
+		headers := map[string]string{}
+		for _, h := range resp.GetResponseHeadersToAdd() {
+			headers[h.GetKey()] = h.GetValue()
+		}
+		assert.Equal(t, "0", headers["X-RateLimit-Remaining"])
+		assert.Equal(t, "60", headers["Retry-After"])
+	})
+
+	t.Run("reports an exhausted quota as over the limit", func(t *testing.T) {
+		storage := testutil.NewCounterStorage()
+		m, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(storage), middleware.Rules{
+			IPLimit:        10,
+			TokenLimit:     10,
+			IPDuration:     time.Second,
+			IPBlockTime:    time.Minute,
+			TokenBlockTime: time.Minute,
+			TokenQuota:     quota.Quota{Limit: 1, Period: quota.Monthly},
+		})
+		assert.NoError(t, err)
+		m.SetQuotaTracker(quota.NewTracker(storage))
+		service := extauthz.NewRateLimitService(m)
+		req := &rlsv3.RateLimitRequest{
+			Domain: "edge",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{
+				descriptor("remote_address", "10.0.0.4"),
+				descriptor("api_key", "quota"),
+			},
+		}
+
+		resp, err := service.ShouldRateLimit(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetOverallCode())
+
+		resp, err = service.ShouldRateLimit(ctx, req)
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.GetOverallCode())
+		assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetStatuses()[0].GetCode())
+		assert.Equal(t, rlsv3.RateLimitResponse_OVER_LIMIT, resp.GetStatuses()[1].GetCode())
+		assert.Greater(t, resp.GetStatuses()[1].GetDurationUntilReset().AsDuration(), time.Minute)
+
+		// Os cabeçalhos vêm da cota esgotada, não do IP ainda liberado
+		var retryAfter string
+		for _, h := range resp.GetResponseHeadersToAdd() {
+			if h.GetKey() == "Retry-After" {
+				retryAfter = h.GetValue()
+			}
+		}
+		assert.NotEmpty(t, retryAfter)
+	})
+
+	t.Run("serves the Envoy RLS gRPC API", func(t *testing.T) {
+		listener := bufconn.Listen(1024 * 1024)
+		server := grpc.NewServer()
+		rlsv3.RegisterRateLimitServiceServer(server, service)
+		go func() { _ = server.Serve(listener) }()
+		defer server.Stop()
+
+		conn, err := grpc.NewClient("passthrough:///bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+		assert.NoError(t, err)
+		defer conn.Close()
+
+		resp, err := rlsv3.NewRateLimitServiceClient(conn).ShouldRateLimit(ctx, &rlsv3.RateLimitRequest{
+			Domain:      "edge",
+			Descriptors: []*ratelimitv3.RateLimitDescriptor{descriptor("remote_address", "10.0.0.3")},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, rlsv3.RateLimitResponse_OK, resp.GetOverallCode())
+	})
+}
@@ -0,0 +1,113 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/health"
+)
+
+type pingStorage struct {
+	err   error
+	delay time.Duration
+}
+
+func (s *pingStorage) Get(string) (int, error)              { return 0, nil }
+func (s *pingStorage) Set(string, int, time.Duration) error { return nil }
+func (s *pingStorage) Incr(string) error                    { return nil }
+func (s *pingStorage) IsBlocked(string) bool                { return false }
+func (s *pingStorage) Block(string, time.Duration) error    { return nil }
+
+func (s *pingStorage) Ping(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+		return s.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func TestLiveness(t *testing.T) {
+	checker := health.NewChecker(time.Second)
+	checker.Add("storage", func(context.Context) error { return errors.New("down") })
+
+	rr := httptest.NewRecorder()
+	checker.Liveness().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("liveness must not depend on storage, got status %d", rr.Code)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name           string
+		storage        *pingStorage
+		ready          bool
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name:           "Ready",
+			storage:        &pingStorage{},
+			ready:          true,
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"config": "ok", "storage": "ok"},
+		},
+		{
+			name:           "Storage unreachable",
+			storage:        &pingStorage{err: errors.New("connection refused")},
+			ready:          true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"config": "ok", "storage": "connection refused"},
+		},
+		{
+			name:           "Storage slower than the timeout",
+			storage:        &pingStorage{delay: time.Second},
+			ready:          true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"config": "ok", "storage": context.DeadlineExceeded.Error()},
+		},
+		{
+			name:           "Not ready yet or shutting down",
+			storage:        &pingStorage{},
+			ready:          false,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"config": "not loaded", "storage": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(50 * time.Millisecond)
+			checker.Add("storage", health.StorageCheck(tt.storage))
+			checker.SetReady(tt.ready)
+
+			rr := httptest.NewRecorder()
+			checker.Readiness().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+			var got report
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid body %q: %v", rr.Body.String(), err)
+			}
+			for name, want := range tt.expectedChecks {
+				if got.Checks[name] != want {
+					t.Errorf("expected check %s=%q, got %q", name, want, got.Checks[name])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package interceptor_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"go-expert-rater-limit/interceptor"
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/quota"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+// setupServer sobe um servidor gRPC em memória (bufconn) com o serviço de
+// health check, que tem um método unário (Check) e um de streaming (Watch).
+func setupServer(t *testing.T) healthpb.HealthClient {
+	t.Helper()
+
+	return setupServerWith(t, middleware.NewRateLimiterMiddleware(
+		limiter.NewRateLimiter(testutil.NewMockStorage()),
+		2,
+		3,
+		time.Second,
+		time.Minute,
+		2*time.Minute,
+	))
+}
+
+func setupServerWith(t *testing.T, rateLimiter *middleware.RateLimiterMiddleware) healthpb.HealthClient {
+	t.Helper()
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(interceptor.UnaryServerInterceptor(rateLimiter)),
+		grpc.StreamInterceptor(interceptor.StreamServerInterceptor(rateLimiter)),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Error dialing bufconn: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return healthpb.NewHealthClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	client := setupServer(t)
+
+	t.Run("limits by peer address", func(t *testing.T) {
+		ctx := context.Background()
+
+		var header metadata.MD
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Header(&header))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"2"}, header.Get("x-ratelimit-limit"))
+		assert.Equal(t, []string{"1"}, header.Get("x-ratelimit-remaining"))
+
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+
+		var trailer metadata.MD
+		_, err = client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, []string{"60"}, trailer.Get("retry-after"))
+
+		details := status.Convert(err).Details()
+		if assert.Len(t, details, 1) {
+			retryInfo, ok := details[0].(*errdetails.RetryInfo)
+			assert.True(t, ok)
+			assert.Equal(t, time.Minute, retryInfo.GetRetryDelay().AsDuration())
+		}
+	})
+
+	t.Run("limits by api-key metadata", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), "api-key", "grpc-token")
+
+		for i := 0; i < 3; i++ {
+			_, err := client.Check(ctx, &healthpb.HealthCheckRequest{})
+			assert.NoError(t, err)
+		}
+
+		var trailer metadata.MD
+		_, err := client.Check(ctx, &healthpb.HealthCheckRequest{}, grpc.Trailer(&trailer))
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, []string{"120"}, trailer.Get("retry-after"))
+	})
+}
+
+func TestUnaryServerInterceptorQuota(t *testing.T) {
+	storage := testutil.NewCounterStorage()
+	rateLimiter, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(storage), middleware.Rules{
+		IPLimit:        10,
+		TokenLimit:     10,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		TokenQuota:     quota.Quota{Limit: 1, Period: quota.Monthly},
+	})
+	assert.NoError(t, err)
+	rateLimiter.SetQuotaTracker(quota.NewTracker(storage))
+	client := setupServerWith(t, rateLimiter)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "api-key", "quota-token")
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+
+	// Cota esgotada: PermissionDenied, com a espera até a renovação
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	details := status.Convert(err).Details()
+	if assert.Len(t, details, 1) {
+		retryInfo, ok := details[0].(*errdetails.RetryInfo)
+		assert.True(t, ok)
+		assert.Greater(t, retryInfo.GetRetryDelay().AsDuration(), time.Minute)
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	client := setupServer(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "api-key", "stream-token")
+
+	for i := 0; i < 3; i++ {
+		stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+		assert.NoError(t, err)
+		_, err = stream.Recv()
+		assert.NoError(t, err)
+	}
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"120"}, stream.Trailer().Get("retry-after"))
+}
@@ -0,0 +1,187 @@
+package limiter
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	limiter2 "go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestBatchedRateLimiter(t *testing.T) {
+	t.Run("instância única não excede o limite", func(t *testing.T) {
+		limiter := limiter2.NewBatchedRateLimiter(testutil.NewMockStorage(), time.Hour)
+		defer limiter.Close()
+
+		allowed := 0
+		for i := 0; i < 15; i++ {
+			if limiter.IsAllowed("single", 10, time.Second, time.Minute) {
+				allowed++
+			}
+		}
+
+		if allowed != 10 {
+			t.Errorf("allowed = %d, want 10", allowed)
+		}
+	})
+
+	t.Run("sincroniza os deltas com o storage", func(t *testing.T) {
+		storage := testutil.NewMockStorage()
+		limiter := limiter2.NewBatchedRateLimiter(storage, time.Hour)
+
+		for i := 0; i < 4; i++ {
+			limiter.IsAllowed("sync", 10, time.Second, time.Minute)
+		}
+		if got, _ := storage.Get("sync"); got != 0 {
+			t.Errorf("storage antes do flush = %d, want 0", got)
+		}
+
+		limiter.Flush()
+		if got, _ := storage.Get("sync"); got != 4 {
+			t.Errorf("storage após flush = %d, want 4", got)
+		}
+
+		limiter.IsAllowed("sync", 10, time.Second, time.Minute)
+		limiter.Close()
+		if got, _ := storage.Get("sync"); got != 5 {
+			t.Errorf("storage após Close = %d, want 5", got)
+		}
+	})
+
+	t.Run("propaga o bloqueio para o storage", func(t *testing.T) {
+		storage := testutil.NewMockStorage()
+		limiter := limiter2.NewBatchedRateLimiter(storage, time.Hour)
+
+		for i := 0; i < 4; i++ {
+			limiter.IsAllowed("block", 3, time.Second, time.Minute)
+		}
+		limiter.Flush()
+
+		if !storage.IsBlocked("block") {
+			t.Error("expected key to be blocked in storage")
+		}
+		if limiter.IsAllowed("block", 3, time.Second, time.Minute) {
+			t.Error("expected key to remain blocked")
+		}
+		limiter.Close()
+	})
+
+	t.Run("respeita bloqueios feitos por outras instâncias", func(t *testing.T) {
+		storage := testutil.NewMockStorage()
+		limiter := limiter2.NewBatchedRateLimiter(storage, time.Hour)
+		defer limiter.Close()
+
+		if !limiter.IsAllowed("remote", 10, time.Second, time.Minute) {
+			t.Fatal("expected first request to be allowed")
+		}
+
+		_ = storage.Block("remote", time.Minute)
+		limiter.Flush()
+
+		if limiter.IsAllowed("remote", 10, time.Second, time.Minute) {
+			t.Error("expected key blocked by another instance to be rejected")
+		}
+	})
+}
+
+// O excesso máximo é de (N-1) * limit por intervalo de sincronização: cada
+// instância só conhece o próprio tráfego até o próximo Flush.
+func TestBatchedRateLimiterOvershoot(t *testing.T) {
+	const (
+		instances = 3
+		limit     = 10
+		requests  = 200
+	)
+
+	tests := []struct {
+		name                string
+		requestsPerInterval int
+	}{
+		{name: "sincronização a cada requisição", requestsPerInterval: 1},
+		{name: "sincronização a cada 6 requisições", requestsPerInterval: 6},
+		{name: "sincronização a cada 15 requisições", requestsPerInterval: 15},
+		{name: "sem sincronização", requestsPerInterval: requests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := testutil.NewMockStorage()
+			limiters := make([]*limiter2.BatchedRateLimiter, instances)
+			for i := range limiters {
+				limiters[i] = limiter2.NewBatchedRateLimiter(storage, time.Hour)
+			}
+
+			allowed := 0
+			for i := 0; i < requests; i++ {
+				if limiters[i%instances].IsAllowed("overshoot", limit, time.Minute, time.Minute) {
+					allowed++
+				}
+				if (i+1)%tt.requestsPerInterval == 0 {
+					for _, l := range limiters {
+						l.Flush()
+					}
+				}
+			}
+			for _, l := range limiters {
+				l.Close()
+			}
+
+			overshoot := allowed - limit
+			t.Logf("%d instâncias, limite %d: %d permitidas, excesso de %d", instances, limit, allowed, overshoot)
+
+			if allowed < limit {
+				t.Errorf("allowed = %d, want at least %d", allowed, limit)
+			}
+			if overshoot > (instances-1)*limit {
+				t.Errorf("overshoot = %d, want at most %d", overshoot, (instances-1)*limit)
+			}
+			if tt.requestsPerInterval == 1 && overshoot != 0 {
+				t.Errorf("overshoot = %d, want 0 when syncing after every request", overshoot)
+			}
+		})
+	}
+}
+
+// Instâncias que sincronizam ao mesmo tempo não podem perder os incrementos
+// umas das outras.
+func TestBatchedRateLimiterConcurrentFlush(t *testing.T) {
+	const instances = 10
+
+	storage := latentCounterStorage{testutil.NewCounterStorage()}
+	limiters := make([]*limiter2.BatchedRateLimiter, instances)
+	for i := range limiters {
+		limiters[i] = limiter2.NewBatchedRateLimiter(storage, time.Hour)
+		defer limiters[i].Close()
+	}
+	for _, l := range limiters {
+		for i := 0; i < 5; i++ {
+			l.Check("flush", 100, time.Minute, time.Minute)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, l := range limiters {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Flush()
+		}()
+	}
+	wg.Wait()
+
+	if count, _ := storage.Get("flush"); count != 5*instances {
+		t.Errorf("got %d units after flushing concurrently, want %d", count, 5*instances)
+	}
+}
+
+// latentCounterStorage atrasa a resposta das leituras, como a ida e volta ao
+// Redis, para que os Flush se intercalem.
+type latentCounterStorage struct {
+	testutil.CounterStorage
+}
+
+func (s latentCounterStorage) Get(key string) (int, error) {
+	defer time.Sleep(time.Millisecond)
+	return s.CounterStorage.Get(key)
+}
@@ -0,0 +1,68 @@
+package limiter
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	limiter2 "go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	t.Run("requires a storage with leases", func(t *testing.T) {
+		_, err := limiter2.NewConcurrencyLimiter(testutil.NewMockStorage(), time.Second)
+		if !errors.Is(err, limiter2.ErrSemaphoreUnsupported) {
+			t.Errorf("NewConcurrencyLimiter() error = %v, want ErrSemaphoreUnsupported", err)
+		}
+	})
+
+	t.Run("holds up to limit slots per key", func(t *testing.T) {
+		storage := testutil.NewSemaphoreStorage()
+		concurrency, err := limiter2.NewConcurrencyLimiter(storage, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		first, acquired, err := concurrency.Acquire("ip:1.2.3.4", 2)
+		if err != nil || !acquired {
+			t.Fatalf("Acquire() = %v, %v, want the first slot", acquired, err)
+		}
+		second, acquired, _ := concurrency.Acquire("ip:1.2.3.4", 2)
+		if !acquired {
+			t.Fatal("expected the second slot")
+		}
+		if _, acquired, _ := concurrency.Acquire("ip:1.2.3.4", 2); acquired {
+			t.Error("expected the third request to be refused")
+		}
+		if _, acquired, _ := concurrency.Acquire("ip:5.6.7.8", 2); !acquired {
+			t.Error("expected other keys to have their own slots")
+		}
+
+		first()
+		first()
+		if held := storage.Held("concurrency:ip:1.2.3.4"); held != 1 {
+			t.Errorf("got %d leases held after releasing one twice, want 1", held)
+		}
+		if _, acquired, _ := concurrency.Acquire("ip:1.2.3.4", 2); !acquired {
+			t.Error("expected the released slot to be available again")
+		}
+		second()
+	})
+
+	t.Run("renews the lease while the slot is held", func(t *testing.T) {
+		storage := testutil.NewSemaphoreStorage()
+		concurrency, err := limiter2.NewConcurrencyLimiter(storage, 30*time.Millisecond)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		release, _, _ := concurrency.Acquire("token:abc", 1)
+		time.Sleep(50 * time.Millisecond)
+		release()
+
+		if storage.Renewed() == 0 {
+			t.Error("expected the lease to be renewed before release")
+		}
+	})
+}
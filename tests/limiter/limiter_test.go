@@ -1,48 +1,15 @@
 package limiter
 
 import (
+	"errors"
 	limiter2 "go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/tests/testutil"
 	"testing"
 	"time"
 )
 
-type MockStorage struct {
-	requests map[string]int
-	blocked  map[string]bool
-}
-
-func NewMockStorage() *MockStorage {
-	return &MockStorage{
-		requests: make(map[string]int),
-		blocked:  make(map[string]bool),
-	}
-}
-
-func (m *MockStorage) Get(key string) (int, error) {
-	return m.requests[key], nil
-}
-
-func (m *MockStorage) Set(key string, value int, _ time.Duration) error {
-	m.requests[key] = value
-	return nil
-}
-
-func (m *MockStorage) Incr(key string) error {
-	m.requests[key]++
-	return nil
-}
-
-func (m *MockStorage) IsBlocked(key string) bool {
-	return m.blocked[key]
-}
-
-func (m *MockStorage) Block(key string, _ time.Duration) error {
-	m.blocked[key] = true
-	return nil
-}
-
 func TestRateLimiter(t *testing.T) {
-	mockStorage := NewMockStorage()
+	mockStorage := testutil.NewMockStorage()
 	limiter := limiter2.NewRateLimiter(mockStorage)
 
 	tests := []struct {
@@ -104,3 +71,133 @@ func TestRateLimiter(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimiterCheck(t *testing.T) {
+	limiter := limiter2.NewRateLimiter(testutil.NewMockStorage())
+
+	result := limiter.Check("check", 3, time.Second, time.Minute)
+	if !result.Allowed || result.Limit != 3 || result.Remaining != 2 {
+		t.Errorf("Check() = %+v, want allowed with 2 remaining", result)
+	}
+
+	limiter.Check("check", 3, time.Second, time.Minute)
+	limiter.Check("check", 3, time.Second, time.Minute)
+
+	result = limiter.Check("check", 3, time.Second, time.Minute)
+	if result.Allowed || result.Remaining != 0 || result.RetryAfter != time.Minute {
+		t.Errorf("Check() = %+v, want rejected with Retry-After of 1m", result)
+	}
+}
+
+func TestRateLimiterCheckN(t *testing.T) {
+	limiter := limiter2.NewRateLimiter(testutil.NewMockStorage())
+
+	result := limiter.CheckN("weighted", 4, 10, time.Second, time.Minute)
+	if !result.Allowed || result.Remaining != 6 {
+		t.Errorf("CheckN() = %+v, want allowed with 6 remaining", result)
+	}
+
+	result = limiter.CheckN("weighted", 5, 10, time.Second, time.Minute)
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("CheckN() = %+v, want allowed with 1 remaining", result)
+	}
+
+	// Não cabe no que resta: rejeita sem bloquear a chave
+	result = limiter.CheckN("weighted", 3, 10, time.Second, time.Minute)
+	if result.Allowed || result.Remaining != 1 || result.RetryAfter != time.Second {
+		t.Errorf("CheckN() = %+v, want rejected with 1 remaining", result)
+	}
+
+	result = limiter.CheckN("weighted", 1, 10, time.Second, time.Minute)
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("CheckN() = %+v, want the last unit to be allowed", result)
+	}
+}
+
+func TestRateLimiterCheckNZero(t *testing.T) {
+	storage := testutil.NewMockStorage()
+	limiter := limiter2.NewRateLimiter(storage)
+
+	result := limiter.CheckN("peek", 0, 2, time.Second, time.Minute)
+	if !result.Allowed || result.Remaining != 2 {
+		t.Errorf("CheckN(0) = %+v, want allowed with 2 remaining", result)
+	}
+	if count, _ := storage.Get("peek"); count != 0 {
+		t.Errorf("CheckN(0) consumed %d units, want 0", count)
+	}
+
+	limiter.CheckN("peek", 2, 2, time.Second, time.Minute)
+	result = limiter.CheckN("peek", 0, 2, time.Second, time.Minute)
+	if result.Allowed {
+		t.Errorf("CheckN(0) = %+v, want rejected once the limit is exhausted", result)
+	}
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	storage := testutil.NewCounterStorage()
+	limiter := limiter2.NewRateLimiter(storage)
+
+	limiter.CheckN("refund", 3, 5, time.Second, time.Minute)
+	if err := limiter.Refund("refund", 2, time.Second); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if count, _ := storage.Get("refund"); count != 1 {
+		t.Errorf("got %d units after refunding 2 of 3, want 1", count)
+	}
+
+	// A janela expirou entre a cobrança e a devolução: o contador não fica negativo
+	if err := limiter.Refund("expired", 2, time.Second); err != nil {
+		t.Fatalf("Refund() error = %v", err)
+	}
+	if count, _ := storage.Get("expired"); count != 0 {
+		t.Errorf("got %d units after refunding an expired window, want 0", count)
+	}
+
+	if err := limiter2.NewRateLimiter(testutil.NewMockStorage()).Refund("refund", 1, time.Second); !errors.Is(err, limiter2.ErrRefundUnsupported) {
+		t.Errorf("Refund() error = %v, want ErrRefundUnsupported", err)
+	}
+}
+
+func TestRateLimiterPeek(t *testing.T) {
+	storage := testutil.NewMockStorage()
+	limiter := limiter2.NewRateLimiter(storage)
+
+	if result := limiter.Peek("peek", 1, 1, time.Second); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("expected an empty window to have room, got %+v", result)
+	}
+	limiter.Check("peek", 1, time.Second, time.Minute)
+
+	// Com a janela cheia, Peek rejeita sem bloquear a chave
+	for i := 0; i < 2; i++ {
+		result := limiter.Peek("peek", 1, 1, time.Second)
+		if result.Allowed || result.RetryAfter != time.Second {
+			t.Errorf("expected a rejection until the window ends, got %+v", result)
+		}
+	}
+	if storage.IsBlocked("peek") {
+		t.Error("Peek must not block the key")
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	storage := testutil.NewExpiringStorage()
+	limiter := limiter2.NewRateLimiter(storage)
+
+	if result := limiter.Take("take", 2, 3, time.Second); !result.Allowed || result.Remaining != 1 {
+		t.Errorf("Take() = %+v, want allowed with 1 remaining", result)
+	}
+
+	// Não cabe: a reserva é desfeita e a chave não é bloqueada
+	for i := 0; i < 2; i++ {
+		result := limiter.Take("take", 2, 3, time.Second)
+		if result.Allowed || result.RetryAfter != time.Second {
+			t.Errorf("Take() = %+v, want rejected with the window as Retry-After", result)
+		}
+	}
+	if count, _ := storage.Get("take"); count != 2 {
+		t.Errorf("got %d units after the rejected takes, want 2", count)
+	}
+	if storage.IsBlocked("take") {
+		t.Error("Take must not block the key")
+	}
+}
@@ -0,0 +1,60 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	limiter2 "go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestCheckWindows(t *testing.T) {
+	windows := []limiter2.Window{
+		{Limit: 3, Duration: time.Second},
+		{Limit: 5, Duration: time.Minute},
+	}
+
+	t.Run("reports the window with the least remaining", func(t *testing.T) {
+		limiter := limiter2.NewRateLimiter(testutil.NewMockStorage())
+
+		result := limiter2.CheckWindows(limiter, "plan", 1, windows, time.Hour)
+		if !result.Allowed || result.Limit != 3 || result.Remaining != 2 {
+			t.Errorf("CheckWindows() = %+v, want allowed by the 3/s window with 2 remaining", result)
+		}
+	})
+
+	t.Run("rejects when any window is exhausted", func(t *testing.T) {
+		storage := testutil.NewMockStorage()
+		limiter := limiter2.NewRateLimiter(storage)
+
+		// A janela de 1s "virou", mas a de 1min continua com 4 unidades usadas
+		storage.Set("plan", 0, time.Second)
+		storage.Set(limiter2.WindowKey("plan", 1, windows[1]), 4, time.Minute)
+
+		result := limiter2.CheckWindows(limiter, "plan", 1, windows, time.Hour)
+		if !result.Allowed || result.Limit != 5 || result.Remaining != 0 {
+			t.Errorf("CheckWindows() = %+v, want allowed by the 5/min window with 0 remaining", result)
+		}
+
+		result = limiter2.CheckWindows(limiter, "plan", 1, windows, time.Hour)
+		if result.Allowed || result.Limit != 5 || result.RetryAfter != time.Hour {
+			t.Errorf("CheckWindows() = %+v, want rejected by the 5/min window", result)
+		}
+		if count, _ := storage.Get("plan"); count != 1 {
+			t.Errorf("the 1s window counted %d units, want 1: rejected requests must not consume", count)
+		}
+	})
+
+	t.Run("rejects without consuming when n does not fit", func(t *testing.T) {
+		storage := testutil.NewMockStorage()
+		limiter := limiter2.NewRateLimiter(storage)
+
+		result := limiter2.CheckWindows(limiter, "plan", 4, windows, time.Hour)
+		if result.Allowed || result.Limit != 3 || result.RetryAfter != time.Second {
+			t.Errorf("CheckWindows() = %+v, want rejected by the 3/s window", result)
+		}
+		if count, _ := storage.Get(limiter2.WindowKey("plan", 1, windows[1])); count != 0 {
+			t.Errorf("the 1min window counted %d units, want 0", count)
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	limiter2 "go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestWindowLimiterReserve(t *testing.T) {
+	const window = time.Hour
+
+	t.Run("reserva na janela atual até o limite", func(t *testing.T) {
+		limiter := limiter2.NewWindowLimiter(testutil.NewCounterStorage(), 3, window)
+
+		for i := 0; i < 3; i++ {
+			r, err := limiter.Reserve("reserve", 1)
+			if err != nil {
+				t.Fatalf("Reserve() error = %v", err)
+			}
+			if r.Delay() != 0 {
+				t.Errorf("Delay() = %v, want 0", r.Delay())
+			}
+		}
+
+		r, err := limiter.Reserve("reserve", 1)
+		if err != nil {
+			t.Fatalf("Reserve() error = %v", err)
+		}
+		if r.Delay() <= 0 || r.Delay() > window {
+			t.Errorf("Delay() = %v, want until the next window", r.Delay())
+		}
+	})
+
+	t.Run("reserva n unidades de uma vez", func(t *testing.T) {
+		limiter := limiter2.NewWindowLimiter(testutil.NewCounterStorage(), 5, window)
+
+		r, _ := limiter.Reserve("bulk", 4)
+		if r.Delay() != 0 {
+			t.Errorf("Delay() = %v, want 0", r.Delay())
+		}
+		r, _ = limiter.Reserve("bulk", 2)
+		if r.Delay() == 0 {
+			t.Error("expected reservation to be pushed to the next window")
+		}
+		r, _ = limiter.Reserve("bulk", 1)
+		if r.Delay() != 0 {
+			t.Errorf("Delay() = %v, want 0 for the remaining unit", r.Delay())
+		}
+	})
+
+	t.Run("Cancel devolve a cota", func(t *testing.T) {
+		limiter := limiter2.NewWindowLimiter(testutil.NewCounterStorage(), 1, window)
+
+		r, _ := limiter.Reserve("cancel", 1)
+		if err := r.Cancel(); err != nil {
+			t.Fatalf("Cancel() error = %v", err)
+		}
+
+		r, _ = limiter.Reserve("cancel", 1)
+		if r.Delay() != 0 {
+			t.Errorf("Delay() = %v, want 0 after cancel", r.Delay())
+		}
+	})
+
+	t.Run("n maior que o limite", func(t *testing.T) {
+		limiter := limiter2.NewWindowLimiter(testutil.NewCounterStorage(), 2, window)
+
+		if _, err := limiter.Reserve("too-big", 3); !errors.Is(err, limiter2.ErrExceedsLimit) {
+			t.Errorf("Reserve() error = %v, want ErrExceedsLimit", err)
+		}
+	})
+}
+
+func TestWindowLimiterWait(t *testing.T) {
+	t.Run("aguarda a próxima janela", func(t *testing.T) {
+		limiter := limiter2.NewWindowLimiter(testutil.NewCounterStorage(), 2, 50*time.Millisecond)
+
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			if err := limiter.Wait(context.Background(), "wait"); err != nil {
+				t.Fatalf("Wait() error = %v", err)
+			}
+		}
+
+		// 5 unidades com 2 por janela ocupam 3 janelas: pelo menos 2 trocas de janela
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("Wait() returned after %v, expected to block until later windows", elapsed)
+		}
+	})
+
+	t.Run("não espera além do deadline do contexto", func(t *testing.T) {
+		storage := testutil.NewCounterStorage()
+		limiter := limiter2.NewWindowLimiter(storage, 1, time.Hour)
+		_ = limiter.Wait(context.Background(), "deadline")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		if err := limiter.Wait(ctx, "deadline"); err == nil {
+			t.Fatal("expected Wait() to fail")
+		}
+		if time.Since(start) > 5*time.Millisecond {
+			t.Error("expected Wait() to fail without blocking")
+		}
+	})
+
+	t.Run("cancelamento libera a reserva", func(t *testing.T) {
+		limiter := limiter2.NewWindowLimiter(testutil.NewCounterStorage(), 1, time.Hour)
+		_ = limiter.Wait(context.Background(), "cancelled")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() { done <- limiter.Wait(ctx, "cancelled") }()
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Fatalf("Wait() error = %v, want context.Canceled", err)
+		}
+
+		r, _ := limiter.Reserve("cancelled", 1)
+		if r.Delay() <= 0 || r.Delay() > time.Hour {
+			t.Errorf("Delay() = %v, want the reservation in the next window", r.Delay())
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestRateLimiterMiddlewareConcurrency(t *testing.T) {
+	storage := testutil.NewSemaphoreStorage()
+	concurrency, err := limiter.NewConcurrencyLimiter(storage, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rateLimiter, err := middleware.New(limiter.NewRateLimiter(storage),
+		middleware.WithIPLimit(100, time.Second),
+		middleware.WithConcurrency(concurrency, 1, 0),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// O handler faz uma segunda requisição do mesmo IP enquanto a primeira
+	// ainda segura a única vaga
+	var nested *httptest.ResponseRecorder
+	var handler http.Handler
+	handler = rateLimiter.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if nested == nil {
+			nested = httptest.NewRecorder()
+			handler.ServeHTTP(nested, httptest.NewRequest("GET", "/", nil))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got %v want %v", rr.Code, http.StatusOK)
+	}
+	if nested.Code != http.StatusTooManyRequests {
+		t.Errorf("got %v want %v for the request over the concurrency limit", nested.Code, http.StatusTooManyRequests)
+	}
+	if nested.Header().Get("X-Concurrency-Limit") != "1" || nested.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected X-Concurrency-Limit 1 and Retry-After 1, got %v", nested.Header())
+	}
+	if rr.Header().Get("X-Concurrency-Limit") != "" {
+		t.Errorf("expected no X-Concurrency-Limit on admitted requests, got %q", rr.Header().Get("X-Concurrency-Limit"))
+	}
+
+	// Terminada a primeira, a vaga volta
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("got %v want %v after the slot was released", rr.Code, http.StatusOK)
+	}
+
+	// Tokens sem limite de concorrência não disputam vagas
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("API_KEY", "abc123")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("got %v want %v for a token without concurrency limit", rr.Code, http.StatusOK)
+		}
+	}
+}
+
+type failingSemaphore struct {
+	testutil.SemaphoreStorage
+}
+
+func (failingSemaphore) AcquireLease(string, string, int, time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestRateLimiterMiddlewareConcurrencyOrder(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("Requests over the concurrency limit do not consume the rate limit", func(t *testing.T) {
+		storage := testutil.NewSemaphoreStorage()
+		concurrency, err := limiter.NewConcurrencyLimiter(storage, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rateLimiter, err := middleware.New(limiter.NewRateLimiter(storage),
+			middleware.WithIPLimit(2, time.Second),
+			middleware.WithConcurrency(concurrency, 1, 0),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		var nested *httptest.ResponseRecorder
+		var handler http.Handler
+		handler = rateLimiter.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if nested == nil {
+				nested = httptest.NewRecorder()
+				handler.ServeHTTP(nested, httptest.NewRequest("GET", "/", nil))
+			}
+		}))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if nested.Code != http.StatusTooManyRequests {
+			t.Fatalf("got %v want %v for the nested request", nested.Code, http.StatusTooManyRequests)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("got %v want %v: the rejected request used a unit", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("Rate limited requests give the slot back", func(t *testing.T) {
+		storage := testutil.NewSemaphoreStorage()
+		concurrency, err := limiter.NewConcurrencyLimiter(storage, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rateLimiter, err := middleware.New(limiter.NewRateLimiter(storage),
+			middleware.WithIPLimit(1, time.Second),
+			middleware.WithConcurrency(concurrency, 1, 0),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handler := rateLimiter.Handle(ok)
+
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			if rr.Code != want {
+				t.Errorf("request %d: got %v want %v", i+1, rr.Code, want)
+			}
+		}
+		if held := storage.Held("ip:192.0.2.1"); held != 0 {
+			t.Errorf("expected every slot released, %d still held", held)
+		}
+	})
+
+	t.Run("Storage errors are rejected with Retry-After", func(t *testing.T) {
+		storage := failingSemaphore{testutil.NewSemaphoreStorage()}
+		concurrency, err := limiter.NewConcurrencyLimiter(storage, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rateLimiter, err := middleware.New(limiter.NewRateLimiter(storage),
+			middleware.WithIPLimit(1, time.Second),
+			middleware.WithConcurrency(concurrency, 1, 0),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		rr := httptest.NewRecorder()
+		rateLimiter.Handle(ok).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("got %v with Retry-After %q, want %v with 1", rr.Code, rr.Header().Get("Retry-After"), http.StatusTooManyRequests)
+		}
+		if storage.Keys() != 0 {
+			t.Errorf("the rate limit must not be consumed, got %d keys", storage.Keys())
+		}
+	})
+}
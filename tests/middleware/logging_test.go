@@ -0,0 +1,151 @@
+package middleware_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func decisionLogs(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestRateLimiterMiddlewareDecisionLogging(t *testing.T) {
+	tests := []struct {
+		name            string
+		sampleRate      float64
+		rules           middleware.Rules
+		setupRequest    func() *http.Request
+		executeCount    int
+		expectedEntries int
+		expectedFields  map[string]any
+	}{
+		{
+			name:       "Rejections are always logged",
+			sampleRate: 0,
+			rules:      middleware.Rules{IPLimit: 1, TokenLimit: 1, IPDuration: time.Second, IPBlockTime: time.Minute, TokenBlockTime: time.Minute},
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("API_KEY", "secret-token")
+				return req
+			},
+			executeCount:    2,
+			expectedEntries: 1,
+			expectedFields: map[string]any{
+				"key_type": "token", "rule": "token", "reason": "rate_limited", "remaining": float64(0), "limit": float64(1),
+			},
+		},
+		{
+			name:       "Allowed requests are sampled",
+			sampleRate: 1,
+			rules:      middleware.Rules{IPLimit: 5, TokenLimit: 5, IPDuration: time.Second, IPBlockTime: time.Minute, TokenBlockTime: time.Minute},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/", nil)
+			},
+			executeCount:    3,
+			expectedEntries: 3,
+			expectedFields:  map[string]any{"key_type": "ip", "rule": "ip", "reason": "allowed"},
+		},
+		{
+			name:       "Denylisted IPs",
+			sampleRate: 0,
+			rules:      middleware.Rules{IPLimit: 5, TokenLimit: 5, IPDuration: time.Second, IPBlockTime: time.Minute, TokenBlockTime: time.Minute, Denylist: []string{"192.0.2.1"}},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/", nil)
+			},
+			executeCount:    1,
+			expectedEntries: 1,
+			expectedFields:  map[string]any{"rule": "denylist", "reason": "denylisted", "status": float64(http.StatusForbidden)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			logged, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), tt.rules)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			logged.SetDecisionLogger(slog.New(slog.NewJSONHandler(&buf, nil)), tt.sampleRate)
+			handler := logged.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			for i := 0; i < tt.executeCount; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), tt.setupRequest())
+			}
+
+			entries := decisionLogs(t, &buf)
+			if len(entries) != tt.expectedEntries {
+				t.Fatalf("expected %d log entries, got %d:\n%s", tt.expectedEntries, len(entries), buf.String())
+			}
+			last := entries[len(entries)-1]
+			for field, want := range tt.expectedFields {
+				if last[field] != want {
+					t.Errorf("expected %s=%v, got %v", field, want, last[field])
+				}
+			}
+			if strings.Contains(buf.String(), "secret-token") || strings.Contains(buf.String(), "192.0.2.1") {
+				t.Errorf("keys must only be logged as hashes:\n%s", buf.String())
+			}
+			if hash, _ := last["key_hash"].(string); len(hash) != 16 {
+				t.Errorf("expected a 16 character key_hash, got %q", hash)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareKeyHashSecret(t *testing.T) {
+	keyHash := func(secret string) string {
+		var buf bytes.Buffer
+		m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()),
+			middleware.WithIPLimit(5, time.Second),
+			middleware.WithDecisionLogger(slog.New(slog.NewJSONHandler(&buf, nil)), 1),
+			middleware.WithKeyHashSecret([]byte(secret)),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		m.Decide(httptest.NewRequest("GET", "/", nil))
+		hash, _ := decisionLogs(t, &buf)[0]["key_hash"].(string)
+		return hash
+	}
+
+	mac := hmac.New(sha256.New, []byte("shared"))
+	mac.Write([]byte("ip:192.0.2.1"))
+	if got, want := keyHash("shared"), hex.EncodeToString(mac.Sum(nil)[:8]); got != want {
+		t.Errorf("got key_hash %q want the HMAC %q", got, want)
+	}
+	if keyHash("shared") == keyHash("other") {
+		t.Error("different secrets must give different hashes")
+	}
+	// Sem segredo configurado, o do processo é estável entre middlewares
+	if keyHash("") != keyHash("") {
+		t.Error("the per-process secret must be stable")
+	}
+}
@@ -0,0 +1,152 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("Defaults", func(t *testing.T) {
+		m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		decision := m.CheckKey("ip:192.0.2.1")
+		if decision.Result.Limit != 5 || decision.Result.Duration != time.Second {
+			t.Errorf("expected 5 requests per second by IP, got %+v", decision.Result)
+		}
+		if decision := m.CheckKey("token:abc"); decision.Result.Limit != 10 {
+			t.Errorf("expected 10 requests per token, got %+v", decision.Result)
+		}
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()),
+			middleware.WithIPLimit(3, time.Minute),
+			middleware.WithTokenLimit(7),
+			middleware.WithTokenPlans(map[string]middleware.TokenPlan{"premium": {Limit: 100, Duration: time.Second}}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if r := m.CheckKey("ip:192.0.2.1").Result; r.Limit != 3 || r.Duration != time.Minute {
+			t.Errorf("expected 3 requests per minute by IP, got %+v", r)
+		}
+		if r := m.CheckKey("token:abc").Result; r.Limit != 7 {
+			t.Errorf("expected 7 requests per token, got %+v", r)
+		}
+		if r := m.CheckKey("token:premium").Result; r.Limit != 100 {
+			t.Errorf("expected the premium plan, got %+v", r)
+		}
+	})
+
+	t.Run("Invalid rules", func(t *testing.T) {
+		_, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.WithDenylist("not-an-ip"))
+		if err == nil {
+			t.Fatal("expected an error for an invalid denylist entry")
+		}
+	})
+
+	t.Run("Key extractor", func(t *testing.T) {
+		m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()),
+			middleware.WithIPLimit(1, time.Second),
+			middleware.WithKeyExtractor(func(r *http.Request) string {
+				if user := r.Header.Get("X-User"); user != "" {
+					return "user:" + user
+				}
+				return ""
+			}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handler := m.Handle(ok)
+
+		// Usuários diferentes no mesmo IP têm contadores separados
+		for _, user := range []string{"alice", "bob"} {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", user)
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Errorf("user %s: got %v want %v", user, rr.Code, http.StatusOK)
+			}
+		}
+		// Sem usuário vale o IP
+		for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+			if rr.Code != want {
+				t.Errorf("anonymous request %d: got %v want %v", i+1, rr.Code, want)
+			}
+		}
+	})
+
+	t.Run("Skipper", func(t *testing.T) {
+		storage := testutil.NewMockStorage()
+		m, err := middleware.New(limiter.NewRateLimiter(storage),
+			middleware.WithIPLimit(1, time.Second),
+			middleware.WithDenylist("192.0.2.1"),
+			middleware.WithSkipper(func(r *http.Request) bool { return r.URL.Path == "/healthz" }),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handler := m.Handle(ok)
+
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("GET", "/healthz", nil)
+			req.RemoteAddr = "192.0.2.2:1234"
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("skipped request %d: got %v want %v", i+1, rr.Code, http.StatusOK)
+			}
+			if rr.Header().Get("X-RateLimit-Limit") != "" {
+				t.Errorf("skipped requests must not get rate limit headers")
+			}
+		}
+		if storage.Keys() != 0 {
+			t.Errorf("skipped requests must not touch the storage, got %d keys", storage.Keys())
+		}
+
+		// A denylist vale antes dos Skippers
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("denylisted IPs are not skipped: got %v want %v", rr.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("OnLimited", func(t *testing.T) {
+		m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()),
+			middleware.WithIPLimit(1, time.Second),
+			middleware.WithOnLimited(func(w http.ResponseWriter, r *http.Request, decision middleware.Decision) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}),
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		handler := m.Handle(ok)
+
+		var rr *httptest.ResponseRecorder
+		for i := 0; i < 2; i++ {
+			rr = httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		}
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("got %v want %v", rr.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,196 @@
+package middleware_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/storage"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func newQueueHandler(t *testing.T, opts ...middleware.Option) http.Handler {
+	t.Helper()
+	return newQueueHandlerWith(t, testutil.NewExpiringStorage(), opts...)
+}
+
+func newQueueHandlerWith(t *testing.T, storage storage.Storage, opts ...middleware.Option) http.Handler {
+	t.Helper()
+
+	opts = append([]middleware.Option{
+		middleware.WithIPLimit(1, 100*time.Millisecond),
+		middleware.WithTokenLimit(1),
+		middleware.WithBlockTime(100*time.Millisecond, 100*time.Millisecond),
+	}, opts...)
+	rateLimiter, err := middleware.New(limiter.NewRateLimiter(storage), opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return rateLimiter.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// latentStorage atrasa as leituras e os incrementos, abrindo espaço para que
+// requisições concorrentes se intercalem entre a consulta e a gravação.
+type latentStorage struct {
+	*testutil.ExpiringStorage
+}
+
+func (s latentStorage) Get(key string) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	return s.ExpiringStorage.Get(key)
+}
+
+func (s latentStorage) IncrWithExpiration(key string, value int, expiration time.Duration) (int, error) {
+	time.Sleep(2 * time.Millisecond)
+	return s.ExpiringStorage.IncrWithExpiration(key, value, expiration)
+}
+
+// serve devolve o status da requisição e quanto ela levou para ser respondida.
+func serve(handler http.Handler, req *http.Request) (int, time.Duration) {
+	start := time.Now()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr.Code, time.Since(start)
+}
+
+func TestRateLimiterMiddlewareQueue(t *testing.T) {
+	t.Run("holds the request until the window frees up", func(t *testing.T) {
+		handler := newQueueHandler(t, middleware.WithQueue(time.Second, 10))
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		code, elapsed := serve(handler, httptest.NewRequest("GET", "/", nil))
+		if code != http.StatusOK {
+			t.Errorf("got %v want %v for a queued request", code, http.StatusOK)
+		}
+		if elapsed < 50*time.Millisecond {
+			t.Errorf("expected the request to wait for the window, answered in %v", elapsed)
+		}
+	})
+
+	t.Run("waits for the window without applying the block time", func(t *testing.T) {
+		handler := newQueueHandler(t,
+			middleware.WithBlockTime(5*time.Minute, 5*time.Minute),
+			middleware.WithQueue(time.Second, 10),
+		)
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		for i := 0; i < 2; i++ {
+			if code, _ := serve(handler, httptest.NewRequest("GET", "/", nil)); code != http.StatusOK {
+				t.Errorf("queued request %d: got %v want %v", i+1, code, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("concurrent waiters never block the key", func(t *testing.T) {
+		handler := newQueueHandlerWith(t, latentStorage{testutil.NewExpiringStorage()},
+			middleware.WithBlockTime(5*time.Minute, 5*time.Minute),
+			middleware.WithQueue(250*time.Millisecond, 10),
+		)
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var allowed int
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+				if retryAfter := rr.Header().Get("Retry-After"); retryAfter == "300" {
+					t.Errorf("a queued request applied the block time: Retry-After %s", retryAfter)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				if rr.Code == http.StatusOK {
+					allowed++
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed == 0 || allowed > 3 {
+			t.Errorf("got %d queued requests allowed, want between 1 and 3 in 250ms of 100ms windows", allowed)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		if code, _ := serve(handler, httptest.NewRequest("GET", "/", nil)); code != http.StatusOK {
+			t.Errorf("got %v want %v once the window ends: the key must not stay blocked", code, http.StatusOK)
+		}
+	})
+
+	t.Run("rejects right away when the wait is too long", func(t *testing.T) {
+		handler := newQueueHandler(t, middleware.WithQueue(50*time.Millisecond, 10))
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		code, elapsed := serve(handler, httptest.NewRequest("GET", "/", nil))
+		if code != http.StatusTooManyRequests {
+			t.Errorf("got %v want %v", code, http.StatusTooManyRequests)
+		}
+		if elapsed > 50*time.Millisecond {
+			t.Errorf("expected an immediate rejection, answered in %v", elapsed)
+		}
+	})
+
+	t.Run("stops waiting when the client gives up", func(t *testing.T) {
+		handler := newQueueHandler(t, middleware.WithQueue(time.Second, 10))
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		code, elapsed := serve(handler, httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+		if code != http.StatusTooManyRequests {
+			t.Errorf("got %v want %v", code, http.StatusTooManyRequests)
+		}
+		if elapsed > 80*time.Millisecond {
+			t.Errorf("expected the wait to end with the request context, answered in %v", elapsed)
+		}
+	})
+
+	t.Run("rejects right away when the queue is full", func(t *testing.T) {
+		handler := newQueueHandler(t, middleware.WithQueue(time.Second, 1))
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve(handler, httptest.NewRequest("GET", "/", nil))
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		code, elapsed := serve(handler, httptest.NewRequest("GET", "/", nil))
+		wg.Wait()
+		if code != http.StatusTooManyRequests {
+			t.Errorf("got %v want %v", code, http.StatusTooManyRequests)
+		}
+		if elapsed > 50*time.Millisecond {
+			t.Errorf("expected an immediate rejection with the queue full, answered in %v", elapsed)
+		}
+	})
+
+	t.Run("plans can queue while other keys are rejected", func(t *testing.T) {
+		handler := newQueueHandler(t, middleware.WithTokenPlans(map[string]middleware.TokenPlan{
+			"internal": {Limit: 1, Duration: 100 * time.Millisecond, BlockTime: 100 * time.Millisecond, QueueMaxDelay: time.Second},
+		}))
+
+		for i := 0; i < 2; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("API_KEY", "internal")
+			if code, _ := serve(handler, req); code != http.StatusOK {
+				t.Errorf("got %v want %v for the internal plan", code, http.StatusOK)
+			}
+		}
+
+		serve(handler, httptest.NewRequest("GET", "/", nil))
+		if code, _ := serve(handler, httptest.NewRequest("GET", "/", nil)); code != http.StatusTooManyRequests {
+			t.Errorf("got %v want %v for keys without a queue", code, http.StatusTooManyRequests)
+		}
+	})
+}
@@ -0,0 +1,203 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/quota"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func newQuotaMiddleware(t *testing.T) *middleware.RateLimiterMiddleware {
+	t.Helper()
+
+	storage := testutil.NewCounterStorage()
+	quotas, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(storage), middleware.Rules{
+		IPLimit:        2,
+		TokenLimit:     100,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		TokenQuota:     quota.Quota{Limit: 3, Period: quota.Monthly},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	quotas.SetQuotaTracker(quota.NewTracker(storage))
+	return quotas
+}
+
+func TestRateLimiterMiddlewareQuota(t *testing.T) {
+	quotas := newQuotaMiddleware(t)
+	handler := quotas.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("API_KEY", "abc123")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if i == 0 && rr.Header().Get("X-Quota-Remaining") != "2" {
+			t.Errorf("expected X-Quota-Remaining 2, got %q", rr.Header().Get("X-Quota-Remaining"))
+		}
+	}
+
+	// Cota esgotada: 403, diferente do 429 das janelas curtas
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("got %v want %v", rr.Code, http.StatusForbidden)
+	}
+	if !strings.Contains(rr.Body.String(), "monthly quota") {
+		t.Errorf("expected a quota message, got %q", rr.Body.String())
+	}
+	if rr.Header().Get("X-Quota-Reset") == "" || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("expected X-Quota-Reset and Retry-After, got %v", rr.Header())
+	}
+
+	// Sem cota configurada o IP continua sujeito só ao limite por segundo
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.5.1:12345"
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+	}
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr.Header().Get("X-Quota-Limit") != "" {
+		t.Errorf("expected no quota headers for keys without quota, got %q", rr.Header().Get("X-Quota-Limit"))
+	}
+}
+
+func TestRateLimiterMiddlewareQuotaRefundsWindows(t *testing.T) {
+	storage := testutil.NewCounterStorage()
+	quotas, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(storage), middleware.Rules{
+		IPLimit:        2,
+		TokenLimit:     100,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		TokenQuota:     quota.Quota{Limit: 3, Period: quota.Monthly},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	quotas.SetQuotaTracker(quota.NewTracker(storage))
+
+	for i := 0; i < 5; i++ {
+		if decision := quotas.CheckKey("token:abc123"); i >= 3 && decision.Status != http.StatusForbidden {
+			t.Fatalf("got %v want %v", decision.Status, http.StatusForbidden)
+		}
+	}
+
+	// Só as três requisições liberadas pela cota ficam nas janelas
+	if used, _ := storage.Get("token:abc123"); used != 3 {
+		t.Errorf("expected 3 units in the window, got %d", used)
+	}
+}
+
+func TestRateLimiterMiddlewareQuotaHandlers(t *testing.T) {
+	quotas := newQuotaMiddleware(t)
+	handler := quotas.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("API_KEY", "abc123")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	tests := []struct {
+		name           string
+		handler        http.Handler
+		setupRequest   func() *http.Request
+		expectedStatus int
+		expectedUsed   int
+	}{
+		{
+			name:    "Own usage",
+			handler: quotas.QuotaHandler(),
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/quota", nil)
+				req.Header.Set("API_KEY", "abc123")
+				return req
+			},
+			expectedStatus: http.StatusOK,
+			expectedUsed:   1,
+		},
+		{
+			name:    "Previous period",
+			handler: quotas.QuotaHandler(),
+			setupRequest: func() *http.Request {
+				at := time.Now().UTC().AddDate(0, -1, 0).Format("2006-01-02")
+				req := httptest.NewRequest("GET", "/quota?at="+at, nil)
+				req.Header.Set("API_KEY", "abc123")
+				return req
+			},
+			expectedStatus: http.StatusOK,
+			expectedUsed:   0,
+		},
+		{
+			name:    "Invalid date",
+			handler: quotas.QuotaHandler(),
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/quota?at=yesterday", nil)
+				req.Header.Set("API_KEY", "abc123")
+				return req
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:    "Key without quota",
+			handler: quotas.QuotaHandler(),
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/quota", nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:    "Admin lookup by key",
+			handler: quotas.AdminQuotaHandler(),
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/admin/quota?key=token:abc123", nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedUsed:   1,
+		},
+		{
+			name:    "Admin lookup without key",
+			handler: quotas.AdminQuotaHandler(),
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/admin/quota", nil)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rr, tt.setupRequest())
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var usage quota.Usage
+			if err := json.NewDecoder(rr.Body).Decode(&usage); err != nil {
+				t.Fatalf("decoding usage: %v", err)
+			}
+			if usage.Key != "token:abc123" || usage.Limit != 3 || usage.Used != tt.expectedUsed {
+				t.Errorf("got %+v, want token:abc123 with %d of 3 used", usage, tt.expectedUsed)
+			}
+		})
+	}
+}
@@ -1,55 +1,22 @@
 package middleware_test
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"go-expert-rater-limit/limiter"
 	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/policy"
+	"go-expert-rater-limit/tests/testutil"
 )
 
-type MockStorage struct {
-	requests    map[string]int
-	blocked     map[string]bool
-	blockedTime map[string]time.Time
-}
-
-func NewMockStorage() *MockStorage {
-	return &MockStorage{
-		requests:    make(map[string]int),
-		blocked:     make(map[string]bool),
-		blockedTime: make(map[string]time.Time),
-	}
-}
-
-func (m *MockStorage) Get(key string) (int, error) {
-	return m.requests[key], nil
-}
-
-func (m *MockStorage) Set(key string, value int, _ time.Duration) error {
-	m.requests[key] = value
-	return nil
-}
-
-func (m *MockStorage) Incr(key string) error {
-	m.requests[key]++
-	return nil
-}
-
-func (m *MockStorage) IsBlocked(key string) bool {
-	return m.blocked[key]
-}
-
-func (m *MockStorage) Block(key string, duration time.Duration) error {
-	m.blocked[key] = true
-	m.blockedTime[key] = time.Now().Add(duration)
-	return nil
-}
-
 func TestRateLimiterMiddleware(t *testing.T) {
-	storage := NewMockStorage()
+	storage := testutil.NewMockStorage()
 	rateLimiter := limiter.NewRateLimiter(storage)
 
 	middleware := middleware.NewRateLimiterMiddleware(
@@ -167,7 +134,7 @@ func TestRateLimiterMiddleware(t *testing.T) {
 }
 
 func TestRateLimiterMiddlewareHeaders(t *testing.T) {
-	storage := NewMockStorage()
+	storage := testutil.NewMockStorage()
 	rateLimiter := limiter.NewRateLimiter(storage)
 	middleware := middleware.NewRateLimiterMiddleware(
 		rateLimiter,
@@ -238,3 +205,661 @@ func TestRateLimiterMiddlewareHeaders(t *testing.T) {
 		})
 	}
 }
+
+func TestRateLimiterMiddlewareClientIP(t *testing.T) {
+	clientIP, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+		IPLimit:        5,
+		TokenLimit:     10,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		TrustedProxies: []string{"192.168.1.0/24"},
+		Allowlist:      []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		remoteAddr  string
+		headers     map[string]string
+		wantKey     string
+		allowlisted bool
+	}{
+		{
+			name:       "Headers from untrusted clients are ignored",
+			remoteAddr: "203.0.113.9:12345",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.1", "X-Real-IP": "10.0.0.1"},
+			wantKey:    "ip:203.0.113.9",
+		},
+		{
+			name:       "X-Real-IP from a trusted proxy",
+			remoteAddr: "192.168.1.1:12345",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.1"},
+			wantKey:    "ip:198.51.100.1",
+		},
+		{
+			name:       "Rightmost untrusted X-Forwarded-For hop",
+			remoteAddr: "192.168.1.1:12345",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.1, 198.51.100.2, 192.168.1.7"},
+			wantKey:    "ip:198.51.100.2",
+		},
+		{
+			name:        "Allowlisted client behind a trusted proxy",
+			remoteAddr:  "192.168.1.1:12345",
+			headers:     map[string]string{"X-Forwarded-For": "10.0.0.1"},
+			wantKey:     "ip:10.0.0.1",
+			allowlisted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+
+			decision := clientIP.Decide(req)
+			if decision.Key != tt.wantKey {
+				t.Errorf("got key %q, want %q", decision.Key, tt.wantKey)
+			}
+			// Chaves da allowlist não passam pelo limiter
+			if allowlisted := decision.Result.Limit == 0; allowlisted != tt.allowlisted {
+				t.Errorf("got %+v, want allowlisted %v", decision, tt.allowlisted)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareRules(t *testing.T) {
+	rateLimiter := limiter.NewRateLimiter(testutil.NewMockStorage())
+	rulesMiddleware, err := middleware.NewRateLimiterMiddlewareWithRules(rateLimiter, middleware.Rules{
+		IPLimit:        5,
+		TokenLimit:     10,
+		IPDuration:     time.Second,
+		IPBlockTime:    5 * time.Minute,
+		TokenBlockTime: 6 * time.Minute,
+		TokenPlans: map[string]middleware.TokenPlan{
+			"premium-token": {Limit: 20, Duration: time.Second, BlockTime: time.Minute},
+		},
+		Allowlist: []string{"10.0.0.0/8"},
+		Denylist:  []string{"203.0.113.7"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handler := rulesMiddleware.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		token          string
+		executeCount   int
+		expectedStatus int
+	}{
+		{
+			name:           "Token plan overrides the default token limit",
+			token:          "premium-token",
+			executeCount:   20,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Token plan limit is enforced",
+			token:          "premium-token",
+			executeCount:   1,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "Allowlisted IP is never limited",
+			remoteAddr:     "10.1.2.3:12345",
+			executeCount:   50,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Denylisted IP is forbidden",
+			remoteAddr:     "203.0.113.7:12345",
+			executeCount:   1,
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastStatus int
+			for i := 0; i < tt.executeCount; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				if tt.remoteAddr != "" {
+					req.RemoteAddr = tt.remoteAddr
+				}
+				if tt.token != "" {
+					req.Header.Set("API_KEY", tt.token)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				lastStatus = rr.Code
+			}
+
+			if lastStatus != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", lastStatus, tt.expectedStatus)
+			}
+		})
+	}
+
+	t.Run("Invalid list entries are rejected", func(t *testing.T) {
+		_, err := middleware.NewRateLimiterMiddlewareWithRules(rateLimiter, middleware.Rules{
+			Allowlist: []string{"not-an-ip"},
+		})
+		if err == nil {
+			t.Error("expected error for invalid allowlist entry")
+		}
+	})
+}
+
+func TestRateLimiterMiddlewareSetRules(t *testing.T) {
+	rateLimiter := limiter.NewRateLimiter(testutil.NewMockStorage())
+	reloadable, err := middleware.NewRateLimiterMiddlewareWithRules(rateLimiter, middleware.Rules{
+		IPLimit:     1,
+		IPDuration:  time.Second,
+		IPBlockTime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	handler := reloadable.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	status := func(remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if got := status("192.168.2.1:12345"); got != http.StatusOK {
+		t.Fatalf("got %v want %v", got, http.StatusOK)
+	}
+
+	err = reloadable.SetRules(middleware.Rules{
+		IPLimit:     1,
+		IPDuration:  time.Second,
+		IPBlockTime: time.Minute,
+		Denylist:    []string{"192.168.2.2"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := status("192.168.2.2:12345"); got != http.StatusForbidden {
+		t.Errorf("new rules not applied: got %v want %v", got, http.StatusForbidden)
+	}
+
+	err = reloadable.SetRules(middleware.Rules{Denylist: []string{"not-an-ip"}})
+	if err == nil {
+		t.Fatal("expected invalid rules to be rejected")
+	}
+	if got := status("192.168.2.2:12345"); got != http.StatusForbidden {
+		t.Errorf("previous rules not kept: got %v want %v", got, http.StatusForbidden)
+	}
+}
+
+type MockPolicyStore map[string]policy.Policy
+
+func (m MockPolicyStore) Lookup(key string) (policy.Policy, bool) {
+	p, ok := m[key]
+	return p, ok
+}
+
+func TestRateLimiterMiddlewarePolicyStore(t *testing.T) {
+	rateLimiter := limiter.NewRateLimiter(testutil.NewMockStorage())
+	dynamic := middleware.NewRateLimiterMiddleware(rateLimiter, 5, 10, time.Second, 5*time.Minute, 6*time.Minute)
+	dynamic.SetPolicyStore(MockPolicyStore{
+		"token:dynamic-token": {Limit: 2, Duration: time.Second, BlockTime: time.Minute},
+		"ip:192.168.3.1":      {Limit: 20, Duration: time.Second, BlockTime: time.Minute},
+	})
+
+	handler := dynamic.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name           string
+		remoteAddr     string
+		token          string
+		executeCount   int
+		expectedStatus int
+	}{
+		{
+			name:           "Dynamic token policy is enforced",
+			token:          "dynamic-token",
+			executeCount:   3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:           "Dynamic IP policy is enforced",
+			remoteAddr:     "192.168.3.1:12345",
+			executeCount:   20,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Static limits apply without a policy",
+			remoteAddr:     "192.168.3.2:12345",
+			executeCount:   6,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastStatus int
+			for i := 0; i < tt.executeCount; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				if tt.remoteAddr != "" {
+					req.RemoteAddr = tt.remoteAddr
+				}
+				if tt.token != "" {
+					req.Header.Set("API_KEY", tt.token)
+				}
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, req)
+				lastStatus = rr.Code
+			}
+
+			if lastStatus != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", lastStatus, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareRateLimitHeaders(t *testing.T) {
+	rateLimiter := limiter.NewRateLimiter(testutil.NewMockStorage())
+	headers := middleware.NewRateLimiterMiddleware(rateLimiter, 2, 10, time.Second, 5*time.Minute, 6*time.Minute)
+	handler := headers.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.168.4.1:12345"
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if i == 0 && rr.Header().Get("X-RateLimit-Remaining") != "1" {
+			t.Errorf("expected X-RateLimit-Remaining 1, got %q", rr.Header().Get("X-RateLimit-Remaining"))
+		}
+	}
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if got := rr.Header().Get("X-RateLimit-Limit"); got != "2" {
+		t.Errorf("expected X-RateLimit-Limit 2, got %q", got)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "300" {
+		t.Errorf("expected Retry-After 300, got %q", got)
+	}
+}
+
+func TestRateLimiterMiddlewareRouteCosts(t *testing.T) {
+	tests := []struct {
+		name           string
+		route          middleware.RouteCost
+		setupRequest   func() *http.Request
+		executeCount   int
+		expectedStatus int
+	}{
+		{
+			name:  "Fixed route cost",
+			route: middleware.RouteCost{Method: "POST", PathPrefix: "/export", Cost: 4},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/export/csv", nil)
+			},
+			executeCount:   3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:  "Other methods cost one unit",
+			route: middleware.RouteCost{Method: "POST", PathPrefix: "/export", Cost: 4},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/export/csv", nil)
+			},
+			executeCount:   10,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "Cost from query param",
+			route: middleware.RouteCost{PathPrefix: "/search", QueryParam: "pages"},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/search?pages=11", nil)
+			},
+			executeCount:   1,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:  "Query param cannot lower the fixed cost",
+			route: middleware.RouteCost{PathPrefix: "/search", Cost: 4, QueryParam: "pages"},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("GET", "/search?pages=1", nil)
+			},
+			executeCount:   3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:  "Cost from body size",
+			route: middleware.RouteCost{PathPrefix: "/upload", BodyBytesPerUnit: 10},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("x", 35)))
+			},
+			executeCount:   2,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "Cost from a chunked body read by the handler",
+			route: middleware.RouteCost{PathPrefix: "/upload", BodyBytesPerUnit: 10},
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("POST", "/upload", io.NopCloser(strings.NewReader(strings.Repeat("x", 35))))
+				req.ContentLength = -1
+				return req
+			},
+			executeCount:   3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:  "Prefixes match whole path segments",
+			route: middleware.RouteCost{Method: "POST", PathPrefix: "/export", Cost: 4},
+			setupRequest: func() *http.Request {
+				return httptest.NewRequest("POST", "/exporter", nil)
+			},
+			executeCount:   10,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Cost from custom function",
+			route: middleware.RouteCost{PathPrefix: "/", Func: func(r *http.Request) int {
+				return len(r.Header.Values("X-Item"))
+			}},
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/batch", nil)
+				for i := 0; i < 6; i++ {
+					req.Header.Add("X-Item", "item")
+				}
+				return req
+			},
+			executeCount:   2,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weighted, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+				IPLimit:     10,
+				IPDuration:  time.Second,
+				IPBlockTime: time.Minute,
+				RouteCosts:  []middleware.RouteCost{tt.route},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			handler := weighted.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.Copy(io.Discard, r.Body)
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var lastStatus int
+			for i := 0; i < tt.executeCount; i++ {
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, tt.setupRequest())
+				lastStatus = rr.Code
+			}
+
+			if lastStatus != tt.expectedStatus {
+				t.Errorf("handler returned wrong status code: got %v want %v", lastStatus, tt.expectedStatus)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareOversizedCost(t *testing.T) {
+	weighted, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+		IPLimit:     10,
+		IPDuration:  time.Second,
+		IPBlockTime: time.Minute,
+		RouteCosts:  []middleware.RouteCost{{PathPrefix: "/search", QueryParam: "pages"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := weighted.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/search?pages=11", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "" {
+		t.Errorf("expected no Retry-After for a cost above the limit, got %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/search?pages=10", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected the oversized request not to consume or block the key, got %v", rr.Code)
+	}
+}
+
+func TestRateLimiterMiddlewareResponseCosts(t *testing.T) {
+	tests := []struct {
+		name           string
+		route          middleware.RouteCost
+		handlerStatus  func(i int) int
+		body           string
+		executeCount   int
+		expectedStatus int
+	}{
+		{
+			name:           "Only failures are counted",
+			route:          middleware.RouteCost{Method: "POST", PathPrefix: "/login", OnlyFailures: true},
+			handlerStatus:  func(int) int { return http.StatusOK },
+			executeCount:   10,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Failures exhaust the limit",
+			route:          middleware.RouteCost{Method: "POST", PathPrefix: "/login", OnlyFailures: true},
+			handlerStatus:  func(int) int { return http.StatusUnauthorized },
+			executeCount:   4,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name:  "Successes between failures are free",
+			route: middleware.RouteCost{Method: "POST", PathPrefix: "/login", OnlyFailures: true},
+			handlerStatus: func(i int) int {
+				if i%2 == 0 {
+					return http.StatusOK
+				}
+				return http.StatusUnauthorized
+			},
+			executeCount:   6,
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Cost from response size",
+			route:          middleware.RouteCost{PathPrefix: "/login", ResponseBytesPerUnit: 10},
+			handlerStatus:  func(int) int { return http.StatusOK },
+			body:           strings.Repeat("x", 5),
+			executeCount:   2,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Response larger than the remaining quota blocks the next request",
+			route:          middleware.RouteCost{PathPrefix: "/login", ResponseBytesPerUnit: 1},
+			handlerStatus:  func(int) int { return http.StatusOK },
+			body:           strings.Repeat("x", 50),
+			executeCount:   2,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+		{
+			name: "Cost from custom response function",
+			route: middleware.RouteCost{PathPrefix: "/login", ResponseFunc: func(_ *http.Request, status int, _ int64) int {
+				if status >= http.StatusInternalServerError {
+					return 2
+				}
+				return 0
+			}},
+			handlerStatus:  func(int) int { return http.StatusBadGateway },
+			executeCount:   3,
+			expectedStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			postHoc, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewCounterStorage()), middleware.Rules{
+				IPLimit:     3,
+				IPDuration:  time.Second,
+				IPBlockTime: time.Minute,
+				RouteCosts:  []middleware.RouteCost{tt.route},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var lastStatus int
+			for i := 0; i < tt.executeCount; i++ {
+				status := tt.handlerStatus(i)
+				handler := postHoc.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(status)
+					_, _ = w.Write([]byte(tt.body))
+				}))
+				rr := httptest.NewRecorder()
+				handler.ServeHTTP(rr, httptest.NewRequest("POST", "/login", nil))
+				lastStatus = rr.Code
+				if lastStatus == http.StatusTooManyRequests {
+					break
+				}
+			}
+
+			if lastStatus != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, lastStatus)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareOnlyFailuresInFlight(t *testing.T) {
+	postHoc, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewCounterStorage()), middleware.Rules{
+		IPLimit:     3,
+		IPDuration:  time.Second,
+		IPBlockTime: time.Minute,
+		RouteCosts:  []middleware.RouteCost{{Method: "POST", PathPrefix: "/login", OnlyFailures: true}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	handler := postHoc.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+
+	// Três tentativas em andamento reservam toda a janela antes de falhar
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", nil))
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("POST", "/login", nil))
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the fourth parallel attempt to be rejected, got %d", rr.Code)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+}
+
+func TestRateLimiterMiddlewareWindows(t *testing.T) {
+	storage := testutil.NewMockStorage()
+	windowed, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(storage), middleware.Rules{
+		IPLimit:        10,
+		TokenLimit:     10,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		TokenPlans: map[string]middleware.TokenPlan{
+			"pro": {Limit: 10, Duration: time.Second, BlockTime: time.Minute, Windows: []limiter.Window{
+				{Limit: 500, Duration: time.Minute},
+				{Limit: 4, Duration: 24 * time.Hour},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := windowed.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("API_KEY", "pro")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if i == 0 {
+			if got := rr.Header().Get("X-RateLimit-Limit"); got != "4" {
+				t.Errorf("expected the daily window in X-RateLimit-Limit, got %q", got)
+			}
+			if got := rr.Header().Get("X-RateLimit-Remaining"); got != "3" {
+				t.Errorf("expected X-RateLimit-Remaining 3, got %q", got)
+			}
+		}
+	}
+
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("got %v want %v", rr.Code, http.StatusTooManyRequests)
+	}
+	if count, _ := storage.Get("token:pro"); count != 4 {
+		t.Errorf("expected the per-second window to count 4 requests, got %d", count)
+	}
+}
+
+func TestRateLimiterMiddlewareCheckKeyContext(t *testing.T) {
+	storage := testutil.NewContextStorage()
+	m := middleware.NewRateLimiterMiddleware(limiter.NewRateLimiter(storage), 2, 10, time.Second, time.Minute, time.Minute)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "rpc")
+	if decision := m.CheckKeyNContext(ctx, "ip:192.0.2.1", 2); !decision.Result.Allowed || decision.Result.Remaining != 0 {
+		t.Errorf("CheckKeyNContext() = %+v, want allowed with 0 remaining", decision.Result)
+	}
+	if bound := storage.Bound(); bound == nil || bound.Value(ctxKey{}) != "rpc" {
+		t.Error("expected the caller's context to reach the storage")
+	}
+	if decision := m.CheckKeyContext(ctx, "ip:192.0.2.1"); decision.Result.Allowed {
+		t.Errorf("CheckKeyContext() = %+v, want rejected once the limit is exhausted", decision.Result)
+	}
+}
@@ -0,0 +1,162 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func newLimitedHandler(t *testing.T, configure func(*middleware.RateLimiterMiddleware)) http.Handler {
+	t.Helper()
+
+	limited, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+		IPLimit: 1, TokenLimit: 1, IPDuration: time.Second, IPBlockTime: time.Minute, TokenBlockTime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if configure != nil {
+		configure(limited)
+	}
+	return limited.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+// rejected faz duas requisições com o mesmo Accept e devolve a segunda, já
+// acima do limite de 1 por segundo.
+func rejected(handler http.Handler, accept string) *httptest.ResponseRecorder {
+	var rr *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/orders", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+	}
+	return rr
+}
+
+func TestRateLimiterMiddlewareLimitedResponse(t *testing.T) {
+	tests := []struct {
+		name                string
+		accept              string
+		expectedContentType string
+		expectedBody        string
+	}{
+		{
+			name:                "Plain text by default",
+			accept:              "",
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "you have reached the maximum number of requests",
+		},
+		{
+			name:                "Plain text for any type",
+			accept:              "*/*",
+			expectedContentType: "text/plain; charset=utf-8",
+			expectedBody:        "you have reached the maximum number of requests",
+		},
+		{
+			name:                "Problem details for JSON clients",
+			accept:              "application/json",
+			expectedContentType: "application/problem+json",
+			expectedBody:        `"status":429`,
+		},
+		{
+			name:                "HTML for browsers",
+			accept:              "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			expectedContentType: "text/html; charset=utf-8",
+			expectedBody:        "<h1>Too Many Requests</h1>",
+		},
+		{
+			name:                "Quality values decide",
+			accept:              "text/html;q=0.5, application/problem+json",
+			expectedContentType: "application/problem+json",
+			expectedBody:        `"type":"about:blank"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := rejected(newLimitedHandler(t, nil), tt.accept)
+
+			if rr.Code != http.StatusTooManyRequests {
+				t.Fatalf("got %v want %v", rr.Code, http.StatusTooManyRequests)
+			}
+			if got := rr.Header().Get("Content-Type"); got != tt.expectedContentType {
+				t.Errorf("expected Content-Type %q, got %q", tt.expectedContentType, got)
+			}
+			if !strings.Contains(rr.Body.String(), tt.expectedBody) {
+				t.Errorf("expected body to contain %q, got %q", tt.expectedBody, rr.Body.String())
+			}
+			if rr.Header().Get("Vary") != "Accept" {
+				t.Errorf("expected Vary: Accept, got %q", rr.Header().Get("Vary"))
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareProblemDetails(t *testing.T) {
+	rr := rejected(newLimitedHandler(t, nil), "application/problem+json")
+
+	var problem middleware.Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("invalid problem details %q: %v", rr.Body.String(), err)
+	}
+	if problem.Title != "Too Many Requests" || problem.Status != http.StatusTooManyRequests || problem.Instance != "/orders" {
+		t.Errorf("unexpected problem details %+v", problem)
+	}
+	if problem.Limit != 1 || problem.Remaining != 0 || problem.RetryAfter != 60 {
+		t.Errorf("expected limit 1, remaining 0 and retry_after 60, got %+v", problem)
+	}
+	if !strings.Contains(rr.Body.String(), `"remaining":0`) {
+		t.Errorf("remaining must be present even when zero: %s", rr.Body.String())
+	}
+}
+
+func TestRateLimiterMiddlewareLimitedCustomization(t *testing.T) {
+	t.Run("Custom HTML template", func(t *testing.T) {
+		tmpl := template.Must(template.New("custom").Parse(`<p>Slow down, retry in {{.RetryAfter}}s</p>`))
+		handler := newLimitedHandler(t, func(m *middleware.RateLimiterMiddleware) {
+			m.SetLimitedTemplate(tmpl)
+		})
+
+		rr := rejected(handler, "text/html")
+
+		if rr.Body.String() != "<p>Slow down, retry in 60s</p>" {
+			t.Errorf("unexpected body %q", rr.Body.String())
+		}
+	})
+
+	t.Run("OnLimited hook", func(t *testing.T) {
+		var seen middleware.Decision
+		handler := newLimitedHandler(t, func(m *middleware.RateLimiterMiddleware) {
+			m.SetOnLimited(func(w http.ResponseWriter, r *http.Request, decision middleware.Decision) {
+				seen = decision
+				w.WriteHeader(http.StatusServiceUnavailable)
+				_, _ = w.Write([]byte("custom"))
+			})
+		})
+
+		rr := rejected(handler, "application/json")
+
+		if rr.Code != http.StatusServiceUnavailable || rr.Body.String() != "custom" {
+			t.Errorf("expected the hook response, got %d %q", rr.Code, rr.Body.String())
+		}
+		if seen.Status != http.StatusTooManyRequests || seen.Result.Limit != 1 {
+			t.Errorf("expected the hook to receive the decision, got %+v", seen)
+		}
+		if rr.Header().Get("X-RateLimit-Limit") != "1" || rr.Header().Get("Retry-After") == "" {
+			t.Errorf("expected the rate limit headers to be set before the hook, got %v", rr.Header())
+		}
+	})
+}
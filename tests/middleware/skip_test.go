@@ -0,0 +1,226 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestRateLimiterMiddlewareSkipRules(t *testing.T) {
+	secret := []byte("bypass-secret")
+
+	tests := []struct {
+		name         string
+		setupRequest func() *http.Request
+		skipped      bool
+	}{
+		{
+			name:         "Health check path",
+			setupRequest: func() *http.Request { return httptest.NewRequest("GET", "/healthz", nil) },
+			skipped:      true,
+		},
+		{
+			name:         "Path prefix is not enough",
+			setupRequest: func() *http.Request { return httptest.NewRequest("GET", "/healthz/deep", nil) },
+		},
+		{
+			name: "CORS preflight",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("OPTIONS", "/orders", nil)
+				req.Header.Set("Origin", "https://app.example.com")
+				req.Header.Set("Access-Control-Request-Method", "POST")
+				return req
+			},
+			skipped: true,
+		},
+		{
+			name:         "Plain OPTIONS is still limited",
+			setupRequest: func() *http.Request { return httptest.NewRequest("OPTIONS", "/orders", nil) },
+		},
+		{
+			name:         "Skipped method",
+			setupRequest: func() *http.Request { return httptest.NewRequest("HEAD", "/orders", nil) },
+			skipped:      true,
+		},
+		{
+			name: "Internal network",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.RemoteAddr = "10.1.2.3:4567"
+				return req
+			},
+			skipped: true,
+		},
+		{
+			name: "Valid bypass signature",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", middleware.SignBypass(secret, "GET", "/orders", time.Now()))
+				return req
+			},
+			skipped: true,
+		},
+		{
+			name: "Expired bypass signature",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", middleware.SignBypass(secret, "GET", "/orders", time.Now().Add(-time.Hour)))
+				return req
+			},
+		},
+		{
+			name: "Bypass signed for another path",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", middleware.SignBypass(secret, "GET", "/admin", time.Now()))
+				return req
+			},
+		},
+		{
+			name: "Bypass signed for another method",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", middleware.SignBypass(secret, "DELETE", "/orders", time.Now()))
+				return req
+			},
+		},
+		{
+			name: "Bypass signed far in the future",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", middleware.SignBypass(secret, "GET", "/orders", time.Now().Add(time.Minute)))
+				return req
+			},
+		},
+		{
+			name: "Bypass signed with another secret",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", middleware.SignBypass([]byte("guess"), "GET", "/orders", time.Now()))
+				return req
+			},
+		},
+		{
+			name: "Malformed bypass header",
+			setupRequest: func() *http.Request {
+				req := httptest.NewRequest("GET", "/orders", nil)
+				req.Header.Set("X-Internal-Bypass", "true")
+				return req
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := testutil.NewMockStorage()
+			m, err := middleware.New(limiter.NewRateLimiter(storage),
+				middleware.WithIPLimit(1, time.Second),
+				middleware.WithSkipRules(middleware.SkipRules{
+					Paths:        []string{"/healthz"},
+					Methods:      []string{"HEAD"},
+					Preflight:    true,
+					Networks:     []string{"10.0.0.0/8"},
+					BypassSecret: string(secret),
+					BypassHeader: "X-Internal-Bypass",
+					BypassMaxAge: time.Minute,
+				}),
+			)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			handler := m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			var rr *httptest.ResponseRecorder
+			for i := 0; i < 2; i++ {
+				rr = httptest.NewRecorder()
+				handler.ServeHTTP(rr, tt.setupRequest())
+			}
+
+			if tt.skipped {
+				if rr.Code != http.StatusOK {
+					t.Errorf("got %v want %v", rr.Code, http.StatusOK)
+				}
+				if storage.Keys() != 0 {
+					t.Errorf("skipped requests must not touch the storage, got %d keys", storage.Keys())
+				}
+			} else if rr.Code != http.StatusTooManyRequests {
+				t.Errorf("got %v want %v", rr.Code, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
+
+func TestRateLimiterMiddlewareSkipRulesReload(t *testing.T) {
+	m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.WithIPLimit(1, time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	err = m.SetRules(middleware.Rules{IPLimit: 1, IPDuration: time.Second, Skip: middleware.SkipRules{Networks: []string{"10.0.0.0/33"}}})
+	if err == nil {
+		t.Fatal("expected an error for an invalid skip network")
+	}
+
+	if err := m.SetRules(middleware.Rules{IPLimit: 1, IPDuration: time.Second, Skip: middleware.SkipRules{Paths: []string{"/status"}}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if decision := m.Decide(httptest.NewRequest("GET", "/status", nil)); decision.Status != http.StatusOK {
+			t.Fatalf("request %d: got %v want %v", i+1, decision.Status, http.StatusOK)
+		}
+	}
+}
+
+func TestRateLimiterMiddlewareSkipRulesDenylistFirst(t *testing.T) {
+	m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()),
+		middleware.WithIPLimit(1, time.Second),
+		middleware.WithDenylist("10.1.2.3"),
+		middleware.WithSkipRules(middleware.SkipRules{
+			Paths:    []string{"/healthz"},
+			Networks: []string{"10.0.0.0/8"},
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	if decision := m.Decide(req); decision.Status != http.StatusForbidden {
+		t.Errorf("got %v want %v", decision.Status, http.StatusForbidden)
+	}
+}
+
+func TestRateLimiterMiddlewareStripsBypassHeader(t *testing.T) {
+	secret := []byte("bypass-secret")
+	m, err := middleware.New(limiter.NewRateLimiter(testutil.NewMockStorage()),
+		middleware.WithIPLimit(1, time.Second),
+		middleware.WithSkipRules(middleware.SkipRules{BypassSecret: string(secret)}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var forwarded string
+	handler := m.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(middleware.DefaultBypassHeader)
+	}))
+
+	req := httptest.NewRequest("GET", "/orders", nil)
+	req.Header.Set(middleware.DefaultBypassHeader, middleware.SignBypass(secret, "GET", "/orders", time.Now()))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if forwarded != "" {
+		t.Errorf("bypass header reached the handler: %q", forwarded)
+	}
+	if req.Header.Get(middleware.DefaultBypassHeader) == "" {
+		t.Error("the caller's request headers must not be modified")
+	}
+}
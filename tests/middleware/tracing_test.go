@@ -0,0 +1,104 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return recorder
+}
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+func TestRateLimiterMiddlewareTracing(t *testing.T) {
+	recorder := setupTracing(t)
+
+	traced, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(testutil.NewMockStorage()), middleware.Rules{
+		IPLimit: 1, TokenLimit: 1, IPDuration: time.Second, IPBlockTime: time.Minute, TokenBlockTime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var nextSpan trace.SpanContext
+	handler := traced.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	for _, span := range spans {
+		if span.Name() != "RateLimiterMiddleware.Handle" {
+			t.Errorf("unexpected span name %q", span.Name())
+		}
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("expected the incoming trace %s to be continued, got %s", traceID, got)
+		}
+		if got := span.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+			t.Errorf("expected the incoming span as parent, got %s", got)
+		}
+	}
+	if nextSpan.SpanID() != spans[0].SpanContext().SpanID() {
+		t.Errorf("expected the next handler to run inside the middleware span")
+	}
+
+	allowed, rejected := spanAttributes(spans[0]), spanAttributes(spans[1])
+	if got := allowed["ratelimit.reason"].AsString(); got != "allowed" {
+		t.Errorf("expected reason allowed, got %q", got)
+	}
+	if got := rejected["ratelimit.reason"].AsString(); got != "rate_limited" {
+		t.Errorf("expected reason rate_limited, got %q", got)
+	}
+	if got := rejected["ratelimit.status"].AsInt64(); got != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", got)
+	}
+	if got := rejected["ratelimit.key_type"].AsString(); got != "ip" {
+		t.Errorf("expected key_type ip, got %q", got)
+	}
+	if got := rejected["ratelimit.limit"].AsInt64(); got != 1 {
+		t.Errorf("expected limit 1, got %d", got)
+	}
+	if _, ok := rejected["ratelimit.key_hash"]; !ok {
+		t.Errorf("expected ratelimit.key_hash attribute")
+	}
+}
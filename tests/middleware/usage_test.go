@@ -0,0 +1,85 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/middleware"
+	"go-expert-rater-limit/tests/testutil"
+	"go-expert-rater-limit/usage"
+)
+
+// serveUsage faz 4 requisições com o token do plano, uma com cada token
+// inventado e uma só com o IP, e devolve o relatório do dia.
+func serveUsage(t *testing.T, fold bool) []usage.Record {
+	t.Helper()
+
+	storage := testutil.NewFieldStorage()
+	recorder := usage.NewRecorder(storage, time.Hour)
+	reporting, err := middleware.NewRateLimiterMiddlewareWithRules(limiter.NewRateLimiter(storage), middleware.Rules{
+		IPLimit:        10,
+		TokenLimit:     2,
+		IPDuration:     time.Second,
+		IPBlockTime:    time.Minute,
+		TokenBlockTime: time.Minute,
+		TokenPlans: map[string]middleware.TokenPlan{
+			"abc123": {Limit: 2, Duration: time.Second, BlockTime: time.Minute},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reporting.SetUsageRecorder(recorder)
+	reporting.SetFoldUnknownTokens(fold)
+	handler := reporting.Handle(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 4; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("API_KEY", "abc123")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	for _, token := range []string{"made-up-1", "made-up-2"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("API_KEY", token)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	// Requisições por IP não entram no relatório
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	records, err := recorder.Report(time.Now(), time.Now())
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	return records
+}
+
+func TestRateLimiterMiddlewareUsage(t *testing.T) {
+	today := time.Now().UTC().Format("2006-01-02")
+
+	t.Run("reports each token", func(t *testing.T) {
+		want := []usage.Record{
+			{Date: today, Key: "token:abc123", Allowed: 2, Rejected: 2},
+			{Date: today, Key: "token:made-up-1", Allowed: 1},
+			{Date: today, Key: "token:made-up-2", Allowed: 1},
+		}
+		if records := serveUsage(t, false); !slices.Equal(records, want) {
+			t.Errorf("Report() = %+v, want %+v", records, want)
+		}
+	})
+
+	t.Run("folds tokens without a plan when asked to", func(t *testing.T) {
+		want := []usage.Record{
+			{Date: today, Key: middleware.UnknownTokensKey, Allowed: 2},
+			{Date: today, Key: "token:abc123", Allowed: 2, Rejected: 2},
+		}
+		if records := serveUsage(t, true); !slices.Equal(records, want) {
+			t.Errorf("Report() = %+v, want %+v", records, want)
+		}
+	})
+}
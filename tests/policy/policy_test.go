@@ -0,0 +1,145 @@
+package policy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"go-expert-rater-limit/policy"
+)
+
+// Redis configuration for tests
+func setupRedis(t *testing.T) (*redis.Client, func()) {
+	redisClient := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+		DB:   1,
+	})
+
+	// Test connection
+	_, err := redisClient.Ping(context.Background()).Result()
+	if err != nil {
+		t.Fatalf("Error connecting to Redis: %v", err)
+	}
+
+	// Cleanup function
+	cleanup := func() {
+		redisClient.FlushDB(context.Background())
+		_ = redisClient.Close()
+	}
+
+	return redisClient, cleanup
+}
+
+func TestRedisStore(t *testing.T) {
+	redisClient, cleanup := setupRedis(t)
+	defer cleanup()
+
+	premium := policy.Policy{Limit: 100, Duration: time.Second, BlockTime: time.Minute}
+
+	t.Run("Lookup nonexistent policy", func(t *testing.T) {
+		store := policy.NewRedisStore(redisClient, time.Minute)
+
+		_, ok := store.Lookup("token:none")
+		assert.False(t, ok)
+	})
+
+	t.Run("Set and Lookup", func(t *testing.T) {
+		store := policy.NewRedisStore(redisClient, time.Minute)
+
+		assert.NoError(t, store.Set("token:abc", premium))
+
+		p, ok := store.Lookup("token:abc")
+		assert.True(t, ok)
+		assert.Equal(t, premium, p)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := policy.NewRedisStore(redisClient, time.Minute)
+
+		assert.NoError(t, store.Set("token:deleted", premium))
+		assert.NoError(t, store.Delete("token:deleted"))
+
+		_, ok := store.Lookup("token:deleted")
+		assert.False(t, ok)
+	})
+
+	t.Run("cache expires after TTL", func(t *testing.T) {
+		store := policy.NewRedisStore(redisClient, 50*time.Millisecond)
+
+		_, ok := store.Lookup("ip:10.0.0.1")
+		assert.False(t, ok)
+
+		// Alteração feita sem notificação: só é vista após o TTL
+		redisClient.HSet(context.Background(), "policy:ip:10.0.0.1",
+			"limit", 1, "duration", "1s", "block_time", "1m")
+
+		_, ok = store.Lookup("ip:10.0.0.1")
+		assert.False(t, ok)
+
+		time.Sleep(60 * time.Millisecond)
+
+		p, ok := store.Lookup("ip:10.0.0.1")
+		assert.True(t, ok)
+		assert.Equal(t, 1, p.Limit)
+	})
+
+	t.Run("invalid policies are ignored", func(t *testing.T) {
+		store := policy.NewRedisStore(redisClient, time.Minute)
+
+		redisClient.HSet(context.Background(), "policy:token:broken",
+			"limit", "many", "duration", "1s", "block_time", "1m")
+
+		_, ok := store.Lookup("token:broken")
+		assert.False(t, ok)
+	})
+
+	t.Run("changes are propagated to other instances", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		writer := policy.NewRedisStore(redisClient, time.Minute)
+		reader := policy.NewRedisStore(redisClient, time.Minute)
+		go func() { _ = reader.Run(ctx) }()
+
+		assert.NoError(t, writer.Set("token:shared", premium))
+		p, ok := reader.Lookup("token:shared")
+		assert.True(t, ok)
+		assert.Equal(t, 100, p.Limit)
+
+		// Aguarda a assinatura estar ativa antes de publicar a mudança
+		time.Sleep(50 * time.Millisecond)
+		assert.NoError(t, writer.Set("token:shared", policy.Policy{Limit: 7, Duration: time.Second, BlockTime: time.Minute}))
+
+		assert.Eventually(t, func() bool {
+			p, ok := reader.Lookup("token:shared")
+			return ok && p.Limit == 7
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestRedisStoreRunRetries(t *testing.T) {
+	// Nenhum Redis escuta nesta porta: a assinatura sempre falha.
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer redisClient.Close()
+	store := policy.NewRedisStore(redisClient, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- store.Run(ctx) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("expected Run to keep retrying, returned %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("expected Run to return once the context is cancelled")
+	}
+}
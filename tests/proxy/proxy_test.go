@@ -0,0 +1,132 @@
+package proxy_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"go-expert-rater-limit/proxy"
+)
+
+func newUpstream(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream", name)
+		w.Header().Set("X-Seen-Forwarded-For", r.Header.Get("X-Forwarded-For"))
+		w.Header().Set("X-Seen-Forwarded-Host", r.Header.Get("X-Forwarded-Host"))
+		w.Header().Set("X-Seen-Api-Key", r.Header.Get("API_KEY"))
+		w.Header().Set("X-Seen-Traceparent", r.Header.Get("traceparent"))
+		_, _ = io.WriteString(w, r.URL.Path)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestReverseProxy(t *testing.T) {
+	api := newUpstream(t, "api")
+	web := newUpstream(t, "web")
+
+	reverseProxy, err := proxy.New(map[string]string{
+		"/":    web.URL,
+		"/api": api.URL,
+	})
+	assert.NoError(t, err)
+
+	t.Run("routes by longest prefix", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/api/users", nil)
+		rr := httptest.NewRecorder()
+		reverseProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "api", rr.Header().Get("X-Upstream"))
+		assert.Equal(t, "/api/users", rr.Body.String())
+	})
+
+	t.Run("matches prefixes on path segments", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/apiary", nil)
+		rr := httptest.NewRecorder()
+		reverseProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, "web", rr.Header().Get("X-Upstream"))
+	})
+
+	t.Run("falls back to the root upstream", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/index.html", nil)
+		rr := httptest.NewRecorder()
+		reverseProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, "web", rr.Header().Get("X-Upstream"))
+	})
+
+	t.Run("forwards headers and appends the client IP to X-Forwarded-For", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "http://example.com/api", nil)
+		req.RemoteAddr = "192.168.1.10:12345"
+		req.Header.Set("X-Forwarded-For", "203.0.113.1")
+		req.Header.Set("API_KEY", "abc123")
+		rr := httptest.NewRecorder()
+		reverseProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, "203.0.113.1, 192.168.1.10", rr.Header().Get("X-Seen-Forwarded-For"))
+		assert.Equal(t, "example.com", rr.Header().Get("X-Seen-Forwarded-Host"))
+		assert.Equal(t, "abc123", rr.Header().Get("X-Seen-Api-Key"))
+	})
+
+	t.Run("propagates the trace context to the upstream", func(t *testing.T) {
+		previous := otel.GetTextMapPropagator()
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+		defer otel.SetTextMapPropagator(previous)
+
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled,
+		}))
+		req := httptest.NewRequest("GET", "http://example.com/api", nil).WithContext(ctx)
+		rr := httptest.NewRecorder()
+		reverseProxy.ServeHTTP(rr, req)
+
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", rr.Header().Get("X-Seen-Traceparent"))
+	})
+
+	t.Run("returns 502 when the upstream is down", func(t *testing.T) {
+		down := httptest.NewServer(http.NotFoundHandler())
+		down.Close()
+
+		downProxy, err := proxy.New(map[string]string{"/": down.URL})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		downProxy.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+
+		assert.Equal(t, http.StatusBadGateway, rr.Code)
+	})
+
+	t.Run("returns 404 when no prefix matches", func(t *testing.T) {
+		apiOnly, err := proxy.New(map[string]string{"/api": api.URL})
+		assert.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		apiOnly.ServeHTTP(rr, httptest.NewRequest("GET", "/other", nil))
+
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+
+	t.Run("rejects invalid upstreams", func(t *testing.T) {
+		_, err := proxy.New(map[string]string{"/": "localhost:8080"})
+		assert.Error(t, err)
+
+		_, err = proxy.New(map[string]string{"api": api.URL})
+		assert.Error(t, err)
+
+		_, err = proxy.New(nil)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,114 @@
+package quota_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/quota"
+	"go-expert-rater-limit/tests/testutil"
+)
+
+func TestQuotaBounds(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatalf("loading time zone: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		quota     quota.Quota
+		at        time.Time
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{
+			name:      "Monthly in UTC",
+			quota:     quota.Quota{Limit: 1, Period: quota.Monthly},
+			at:        time.Date(2026, 2, 14, 12, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			wantEnd:   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:  "Monthly in the customer's time zone",
+			quota: quota.Quota{Limit: 1, Period: quota.Monthly, Location: saoPaulo},
+			// 1º de março às 01:00 UTC ainda é fevereiro em São Paulo
+			at:        time.Date(2026, 3, 1, 1, 0, 0, 0, time.UTC),
+			wantStart: time.Date(2026, 2, 1, 0, 0, 0, 0, saoPaulo),
+			wantEnd:   time.Date(2026, 3, 1, 0, 0, 0, 0, saoPaulo),
+		},
+		{
+			name:      "Daily",
+			quota:     quota.Quota{Limit: 1, Period: quota.Daily, Location: saoPaulo},
+			at:        time.Date(2026, 12, 31, 23, 30, 0, 0, saoPaulo),
+			wantStart: time.Date(2026, 12, 31, 0, 0, 0, 0, saoPaulo),
+			wantEnd:   time.Date(2027, 1, 1, 0, 0, 0, 0, saoPaulo),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.quota.Bounds(tt.at)
+			if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+				t.Errorf("Bounds() = [%v, %v), want [%v, %v)", start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestTracker(t *testing.T) {
+	q := quota.Quota{Limit: 5, Period: quota.Monthly}
+
+	t.Run("consumes until the quota is exhausted", func(t *testing.T) {
+		tracker := quota.NewTracker(testutil.NewCounterStorage())
+
+		usage, err := tracker.Consume("token:abc", q, 3)
+		if err != nil || !usage.Allowed || usage.Used != 3 || usage.Remaining != 2 {
+			t.Fatalf("Consume() = %+v, %v, want 3 used and 2 remaining", usage, err)
+		}
+
+		// Não cabe: as unidades são devolvidas
+		usage, err = tracker.Consume("token:abc", q, 3)
+		if err != nil || usage.Allowed || usage.Used != 3 {
+			t.Fatalf("Consume() = %+v, %v, want rejected with 3 used", usage, err)
+		}
+
+		usage, err = tracker.Consume("token:abc", q, 2)
+		if err != nil || !usage.Allowed || usage.Remaining != 0 {
+			t.Fatalf("Consume() = %+v, %v, want the last 2 units allowed", usage, err)
+		}
+
+		usage, err = tracker.Consume("token:abc", q, 0)
+		if err != nil || usage.Allowed {
+			t.Errorf("Consume(0) = %+v, %v, want an exhausted quota", usage, err)
+		}
+	})
+
+	t.Run("looks up the current and previous periods", func(t *testing.T) {
+		tracker := quota.NewTracker(testutil.NewCounterStorage())
+		if _, err := tracker.Consume("ip:10.0.0.1", q, 4); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		usage, err := tracker.Usage("ip:10.0.0.1", q, time.Now())
+		if err != nil || usage.Used != 4 || usage.Limit != 5 {
+			t.Errorf("Usage() = %+v, %v, want 4 of 5 used", usage, err)
+		}
+		if start, end := q.Bounds(time.Now()); !usage.Start.Equal(start) || !usage.ResetAt.Equal(end) {
+			t.Errorf("Usage() period = [%v, %v), want [%v, %v)", usage.Start, usage.ResetAt, start, end)
+		}
+
+		usage, err = tracker.Usage("ip:10.0.0.1", q, time.Now().AddDate(0, -1, 0))
+		if err != nil || usage.Used != 0 {
+			t.Errorf("Usage() of the previous month = %+v, %v, want nothing used", usage, err)
+		}
+	})
+
+	t.Run("requires an atomic counter", func(t *testing.T) {
+		tracker := quota.NewTracker(testutil.NewMockStorage())
+
+		if _, err := tracker.Consume("ip:10.0.0.1", q, 1); !errors.Is(err, quota.ErrCounterUnsupported) {
+			t.Errorf("Consume() error = %v, want ErrCounterUnsupported", err)
+		}
+	})
+}
@@ -7,6 +7,11 @@ import (
 
 	"github.com/go-redis/redis/v8"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
 	"go-expert-rater-limit/storage"
 )
 
@@ -96,6 +101,69 @@ func TestRedisStorage(t *testing.T) {
 		assert.False(t, store.IsBlocked(key))
 	})
 
+	t.Run("IncrBy", func(t *testing.T) {
+		key := "incr_by"
+
+		err := store.IncrBy(key, 5)
+		assert.NoError(t, err)
+
+		err = store.IncrBy(key, 3)
+		assert.NoError(t, err)
+
+		val, err := store.Get(key)
+		assert.NoError(t, err)
+		assert.Equal(t, 8, val)
+	})
+
+	t.Run("IncrWithExpiration", func(t *testing.T) {
+		key := "incr_with_expiration"
+
+		val, err := store.IncrWithExpiration(key, 3, time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, val)
+
+		val, err = store.IncrWithExpiration(key, -1, time.Hour)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, val)
+
+		// A expiração da criação é mantida
+		ttl, err := redisClient.PTTL(context.Background(), key).Result()
+		assert.NoError(t, err)
+		assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+	})
+
+	t.Run("IncrField and GetFields", func(t *testing.T) {
+		key := "fields"
+
+		assert.NoError(t, store.IncrField(key, "a", 2, time.Minute))
+		assert.NoError(t, store.IncrField(key, "a", 1, time.Hour))
+		assert.NoError(t, store.IncrField(key, "b", 5, time.Hour))
+
+		fields, err := store.GetFields(key)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 3, "b": 5}, fields)
+
+		// A expiração é renovada a cada incremento
+		ttl, err := redisClient.PTTL(context.Background(), key).Result()
+		assert.NoError(t, err)
+		assert.InDelta(t, time.Hour, ttl, float64(time.Second))
+
+		fields, err = store.GetFields("nonexistent_fields")
+		assert.NoError(t, err)
+		assert.Empty(t, fields)
+	})
+
+	t.Run("BlockTTL", func(t *testing.T) {
+		key := "block_ttl"
+
+		err := store.Block(key, time.Minute)
+		assert.NoError(t, err)
+
+		ttl, err := store.BlockTTL(key)
+		assert.NoError(t, err)
+		assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+	})
+
 	t.Run("expired key", func(t *testing.T) {
 		key := "expire_test"
 
@@ -132,3 +200,93 @@ func TestRedisStorage(t *testing.T) {
 		assert.Equal(t, 10, val)
 	})
 }
+
+func TestRedisStorageTracing(t *testing.T) {
+	redisClient, cleanup := setupRedis(t)
+	defer cleanup()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	store := storage.NewRedisStorage(redisClient).WithContext(ctx)
+
+	assert.NoError(t, store.Set("traced", 1, time.Minute))
+	_, err := store.Get("traced")
+	assert.NoError(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	if assert.Len(t, spans, 3) {
+		assert.Equal(t, "redis SET", spans[0].Name())
+		assert.Equal(t, "redis GET", spans[1].Name())
+		for _, span := range spans[:2] {
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+			assert.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+			assert.Contains(t, span.Attributes(), attribute.String("db.system", "redis"))
+		}
+	}
+}
+
+func TestRedisStoragePing(t *testing.T) {
+	redisClient, cleanup := setupRedis(t)
+	defer cleanup()
+
+	store := storage.NewRedisStorage(redisClient)
+	assert.NoError(t, store.Ping(context.Background()))
+
+	unreachableClient := redis.NewClient(&redis.Options{Addr: "localhost:1"})
+	defer unreachableClient.Close()
+	assert.Error(t, storage.NewRedisStorage(unreachableClient).Ping(context.Background()))
+}
+
+func TestRedisStorageLeases(t *testing.T) {
+	redisClient, cleanup := setupRedis(t)
+	defer cleanup()
+
+	store := storage.NewRedisStorage(redisClient)
+
+	t.Run("acquires up to the limit", func(t *testing.T) {
+		for _, id := range []string{"a", "b"} {
+			acquired, err := store.AcquireLease("lease:limit", id, 2, time.Minute)
+			assert.NoError(t, err)
+			assert.True(t, acquired)
+		}
+		acquired, err := store.AcquireLease("lease:limit", "c", 2, time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, acquired)
+
+		assert.NoError(t, store.ReleaseLease("lease:limit", "a"))
+		acquired, err = store.AcquireLease("lease:limit", "c", 2, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("expired leases free their slot", func(t *testing.T) {
+		acquired, err := store.AcquireLease("lease:expired", "a", 1, 50*time.Millisecond)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		time.Sleep(100 * time.Millisecond)
+		acquired, err = store.AcquireLease("lease:expired", "b", 1, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+	})
+
+	t.Run("renewed leases stay held", func(t *testing.T) {
+		acquired, err := store.AcquireLease("lease:renewed", "a", 1, 100*time.Millisecond)
+		assert.NoError(t, err)
+		assert.True(t, acquired)
+
+		time.Sleep(60 * time.Millisecond)
+		assert.NoError(t, store.RenewLease("lease:renewed", "a", time.Minute))
+		time.Sleep(60 * time.Millisecond)
+
+		acquired, err = store.AcquireLease("lease:renewed", "b", 1, time.Minute)
+		assert.NoError(t, err)
+		assert.False(t, acquired)
+	})
+}
@@ -0,0 +1,267 @@
+// Package testutil reúne os storages em memória usados pelos testes dos
+// demais pacotes.
+package testutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+// MockStorage guarda contadores e bloqueios em memória, sem expiração. Só
+// implementa storage.Storage; os tipos abaixo acrescentam as interfaces
+// opcionais para os testes que precisam delas.
+type MockStorage struct {
+	mu       sync.Mutex
+	requests map[string]int
+	blocked  map[string]bool
+}
+
+func NewMockStorage() *MockStorage {
+	return &MockStorage{
+		requests: make(map[string]int),
+		blocked:  make(map[string]bool),
+	}
+}
+
+func (m *MockStorage) Get(key string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests[key], nil
+}
+
+func (m *MockStorage) Set(key string, value int, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[key] = value
+	return nil
+}
+
+func (m *MockStorage) Incr(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[key]++
+	return nil
+}
+
+func (m *MockStorage) IsBlocked(key string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.blocked[key]
+}
+
+func (m *MockStorage) Block(key string, _ time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocked[key] = true
+	return nil
+}
+
+// Keys devolve quantas chaves já foram gravadas, somando contadores e
+// bloqueios.
+func (m *MockStorage) Keys() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.requests) + len(m.blocked)
+}
+
+// CounterStorage acrescenta storage.Counter ao MockStorage.
+type CounterStorage struct {
+	*MockStorage
+}
+
+func NewCounterStorage() CounterStorage {
+	return CounterStorage{NewMockStorage()}
+}
+
+func (c CounterStorage) IncrWithExpiration(key string, value int, _ time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests[key] += value
+	return c.requests[key], nil
+}
+
+// FieldStorage acrescenta storage.FieldCounter ao MockStorage.
+type FieldStorage struct {
+	*MockStorage
+	fields map[string]map[string]int
+}
+
+func NewFieldStorage() FieldStorage {
+	return FieldStorage{MockStorage: NewMockStorage(), fields: make(map[string]map[string]int)}
+}
+
+func (f FieldStorage) IncrField(key, field string, value int, _ time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fields[key] == nil {
+		f.fields[key] = make(map[string]int)
+	}
+	f.fields[key][field] += value
+	return nil
+}
+
+func (f FieldStorage) GetFields(key string) (map[string]int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fields := make(map[string]int, len(f.fields[key]))
+	for field, n := range f.fields[key] {
+		fields[field] = n
+	}
+	return fields, nil
+}
+
+// SemaphoreStorage acrescenta storage.Semaphore ao MockStorage. As leases não
+// expiram; Held e Renewed permitem conferir o que o limiter fez com elas.
+type SemaphoreStorage struct {
+	*MockStorage
+	leases  map[string]map[string]bool
+	renewed *int
+}
+
+func NewSemaphoreStorage() SemaphoreStorage {
+	return SemaphoreStorage{MockStorage: NewMockStorage(), leases: make(map[string]map[string]bool), renewed: new(int)}
+}
+
+func (s SemaphoreStorage) AcquireLease(key, id string, limit int, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.leases[key]) >= limit {
+		return false, nil
+	}
+	if s.leases[key] == nil {
+		s.leases[key] = make(map[string]bool)
+	}
+	s.leases[key][id] = true
+	return true, nil
+}
+
+func (s SemaphoreStorage) RenewLease(key, id string, _ time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leases[key][id] {
+		*s.renewed++
+	}
+	return nil
+}
+
+func (s SemaphoreStorage) ReleaseLease(key, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases[key], id)
+	return nil
+}
+
+// Held devolve quantas vagas da chave estão ocupadas.
+func (s SemaphoreStorage) Held(key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.leases[key])
+}
+
+// Renewed devolve quantas renovações de leases ativas foram feitas.
+func (s SemaphoreStorage) Renewed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.renewed
+}
+
+// ExpiringStorage é um storage cujos contadores e bloqueios expiram, para os
+// testes que esperam uma janela renovar. Também implementa
+// storage.BlockTTLReader e storage.Counter.
+type ExpiringStorage struct {
+	mu       sync.Mutex
+	requests map[string]int
+	expires  map[string]time.Time
+	blocked  map[string]time.Time
+}
+
+func NewExpiringStorage() *ExpiringStorage {
+	return &ExpiringStorage{
+		requests: make(map[string]int),
+		expires:  make(map[string]time.Time),
+		blocked:  make(map[string]time.Time),
+	}
+}
+
+func (s *ExpiringStorage) Get(key string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().After(s.expires[key]) {
+		return 0, nil
+	}
+	return s.requests[key], nil
+}
+
+func (s *ExpiringStorage) Set(key string, value int, expiration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[key] = value
+	s.expires[key] = time.Now().Add(expiration)
+	return nil
+}
+
+func (s *ExpiringStorage) Incr(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[key]++
+	return nil
+}
+
+func (s *ExpiringStorage) IncrWithExpiration(key string, value int, expiration time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().After(s.expires[key]) {
+		s.requests[key] = 0
+		s.expires[key] = time.Now().Add(expiration)
+	}
+	s.requests[key] += value
+	return s.requests[key], nil
+}
+
+func (s *ExpiringStorage) IsBlocked(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Now().Before(s.blocked[key])
+}
+
+func (s *ExpiringStorage) Block(key string, duration time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked[key] = time.Now().Add(duration)
+	return nil
+}
+
+func (s *ExpiringStorage) BlockTTL(key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Until(s.blocked[key]), nil
+}
+
+// ContextStorage é um MockStorage que implementa storage.ContextBinder e
+// guarda o último contexto ligado a ele, para os testes que conferem se o
+// contexto da chamada chega ao storage.
+type ContextStorage struct {
+	*MockStorage
+	bound *context.Context
+}
+
+func NewContextStorage() ContextStorage {
+	return ContextStorage{MockStorage: NewMockStorage(), bound: new(context.Context)}
+}
+
+func (s ContextStorage) WithContext(ctx context.Context) storage.Storage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	*s.bound = ctx
+	return s
+}
+
+// Bound devolve o último contexto ligado ao storage, ou nil.
+func (s ContextStorage) Bound() context.Context {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.bound
+}
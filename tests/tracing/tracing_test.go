@@ -0,0 +1,48 @@
+package tracing_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+
+	"go-expert-rater-limit/tracing"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		name        string
+		exporter    string
+		expectError bool
+	}{
+		{name: "Disabled", exporter: tracing.ExporterNone},
+		{name: "Empty means disabled", exporter: ""},
+		{name: "Stdout", exporter: tracing.ExporterStdout},
+		{name: "OTLP", exporter: tracing.ExporterOTLP},
+		{name: "Unknown exporter", exporter: "jaeger", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdown, err := tracing.Setup(context.Background(), tt.exporter)
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected an error for exporter %q", tt.exporter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("unexpected shutdown error: %v", err)
+			}
+
+			fields := otel.GetTextMapPropagator().Fields()
+			if !slices.Contains(fields, "traceparent") || !slices.Contains(fields, "baggage") {
+				t.Errorf("expected the W3C trace context and baggage propagators, got fields %v", fields)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package transport_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"go-expert-rater-limit/limiter"
+	"go-expert-rater-limit/tests/testutil"
+	"go-expert-rater-limit/transport"
+)
+
+func newUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	t.Run("fails when the quota is exhausted", func(t *testing.T) {
+		upstream := newUpstream(t)
+		rateLimiter := limiter.NewRateLimiter(testutil.NewExpiringStorage())
+		client := &http.Client{Transport: transport.NewRateLimitedTransport(nil, rateLimiter, 2, time.Minute, false)}
+
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(upstream.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		_, err := client.Get(upstream.URL)
+		var quotaErr *transport.QuotaExceededError
+		assert.True(t, errors.As(err, &quotaErr))
+		assert.Equal(t, time.Minute, quotaErr.RetryAfter)
+	})
+
+	t.Run("instances sharing storage share the quota", func(t *testing.T) {
+		upstream := newUpstream(t)
+		storage := testutil.NewExpiringStorage()
+		first := &http.Client{Transport: transport.NewRateLimitedTransport(nil, limiter.NewRateLimiter(storage), 3, time.Minute, false)}
+		second := &http.Client{Transport: transport.NewRateLimitedTransport(nil, limiter.NewRateLimiter(storage), 3, time.Minute, false)}
+
+		allowed := 0
+		for i := 0; i < 6; i++ {
+			client := first
+			if i%2 == 1 {
+				client = second
+			}
+			if resp, err := client.Get(upstream.URL); err == nil {
+				resp.Body.Close()
+				allowed++
+			}
+		}
+
+		assert.Equal(t, 3, allowed)
+	})
+
+	t.Run("waits for the quota when configured", func(t *testing.T) {
+		upstream := newUpstream(t)
+		rateLimiter := limiter.NewRateLimiter(testutil.NewExpiringStorage())
+		client := &http.Client{Transport: transport.NewRateLimitedTransport(nil, rateLimiter, 2, 100*time.Millisecond, true)}
+
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			resp, err := client.Get(upstream.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	})
+
+	t.Run("an exhausted quota does not block the host past the window", func(t *testing.T) {
+		upstream := newUpstream(t)
+		rateLimiter := limiter.NewRateLimiter(testutil.NewExpiringStorage())
+		client := &http.Client{Transport: transport.NewRateLimitedTransport(nil, rateLimiter, 2, 100*time.Millisecond, false)}
+
+		for i := 0; i < 2; i++ {
+			resp, err := client.Get(upstream.URL)
+			assert.NoError(t, err)
+			resp.Body.Close()
+		}
+		time.Sleep(60 * time.Millisecond)
+		_, err := client.Get(upstream.URL)
+		assert.Error(t, err)
+
+		time.Sleep(60 * time.Millisecond)
+		resp, err := client.Get(upstream.URL)
+		assert.NoError(t, err)
+		if err == nil {
+			resp.Body.Close()
+		}
+	})
+
+	t.Run("passes the request context to the storage", func(t *testing.T) {
+		upstream := newUpstream(t)
+		storage := testutil.NewContextStorage()
+		client := &http.Client{Transport: transport.NewRateLimitedTransport(nil, limiter.NewRateLimiter(storage), 2, time.Minute, false)}
+
+		type ctxKey struct{}
+		ctx := context.WithValue(context.Background(), ctxKey{}, "outbound")
+		req, _ := http.NewRequestWithContext(ctx, "GET", upstream.URL, nil)
+		resp, err := client.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		assert.NotNil(t, storage.Bound())
+		assert.Equal(t, "outbound", storage.Bound().Value(ctxKey{}))
+	})
+
+	t.Run("stops waiting when the context is cancelled", func(t *testing.T) {
+		upstream := newUpstream(t)
+		rateLimiter := limiter.NewRateLimiter(testutil.NewExpiringStorage())
+		client := &http.Client{Transport: transport.NewRateLimitedTransport(nil, rateLimiter, 1, time.Minute, true)}
+
+		resp, err := client.Get(upstream.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequestWithContext(ctx, "GET", upstream.URL, nil)
+
+		_, err = client.Do(req)
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
@@ -0,0 +1,145 @@
+package usage_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-expert-rater-limit/tests/testutil"
+	"go-expert-rater-limit/usage"
+)
+
+func TestRecorder(t *testing.T) {
+	storage := testutil.NewFieldStorage()
+	recorder := usage.NewRecorder(storage, time.Hour)
+
+	for _, r := range []struct {
+		key     string
+		allowed bool
+	}{
+		{"token:beta", true},
+		{"token:alpha", true},
+		{"token:alpha", true},
+		{"token:alpha", false},
+	} {
+		if err := recorder.Record(r.key, r.allowed); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	// Dia anterior gravado direto no storage
+	yesterday := time.Now().UTC().AddDate(0, 0, -1)
+	storage.IncrField("usage:"+yesterday.Format("2006-01-02"), "rejected:token:alpha", 7, time.Hour)
+
+	records, err := recorder.Report(yesterday, time.Now())
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+
+	today := time.Now().UTC().Format("2006-01-02")
+	want := []usage.Record{
+		{Date: yesterday.Format("2006-01-02"), Key: "token:alpha", Rejected: 7},
+		{Date: today, Key: "token:alpha", Allowed: 2, Rejected: 1},
+		{Date: today, Key: "token:beta", Allowed: 1},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("Report() = %+v, want %+v", records, want)
+	}
+	for i := range want {
+		if records[i] != want[i] {
+			t.Errorf("record %d = %+v, want %+v", i, records[i], want[i])
+		}
+	}
+
+	t.Run("rejects invalid ranges", func(t *testing.T) {
+		if _, err := recorder.Report(time.Now(), yesterday); !errors.Is(err, usage.ErrInvalidRange) {
+			t.Errorf("Report() error = %v, want ErrInvalidRange", err)
+		}
+		if _, err := recorder.Report(time.Now().AddDate(-2, 0, 0), time.Now()); !errors.Is(err, usage.ErrRangeTooLong) {
+			t.Errorf("Report() error = %v, want ErrRangeTooLong", err)
+		}
+	})
+
+	t.Run("requires a field counter", func(t *testing.T) {
+		plain := usage.NewRecorder(testutil.NewMockStorage(), time.Hour)
+		if err := plain.Record("token:alpha", true); !errors.Is(err, usage.ErrFieldsUnsupported) {
+			t.Errorf("Record() error = %v, want ErrFieldsUnsupported", err)
+		}
+	})
+}
+
+func TestWrite(t *testing.T) {
+	records := []usage.Record{
+		{Date: "2026-10-01", Key: "token:alpha", Allowed: 2, Rejected: 1},
+		{Date: "2026-10-01", Key: "token:a,b", Allowed: 1},
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{usage.FormatCSV, "date,key,allowed,rejected\n2026-10-01,token:alpha,2,1\n2026-10-01,\"token:a,b\",1,0\n"},
+		{usage.FormatJSONLines, `{"date":"2026-10-01","key":"token:alpha","allowed":2,"rejected":1}` + "\n" +
+			`{"date":"2026-10-01","key":"token:a,b","allowed":1,"rejected":0}` + "\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := usage.Write(&buf, tt.format, records); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if buf.String() != tt.want {
+				t.Errorf("Write() = %q, want %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	if err := usage.Write(&bytes.Buffer{}, "xml", records); err == nil {
+		t.Error("Write() with an unknown format should fail")
+	}
+}
+
+func TestExportHandler(t *testing.T) {
+	recorder := usage.NewRecorder(testutil.NewFieldStorage(), time.Hour)
+	if err := recorder.Record("token:alpha", true); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	handler := usage.NewExportHandler(recorder)
+	today := time.Now().UTC().Format("2006-01-02")
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedType   string
+		expectedBody   string
+	}{
+		{"CSV by default", "?from=" + today, http.StatusOK, "text/csv; charset=utf-8", "token:alpha,1,0"},
+		{"JSON Lines", "?from=" + today + "&to=" + today + "&format=jsonl", http.StatusOK, "application/jsonl", `"allowed":1`},
+		{"Missing from", "", http.StatusBadRequest, "", ""},
+		{"Invalid to", "?from=" + today + "&to=tomorrow", http.StatusBadRequest, "", ""},
+		{"Inverted range", "?from=2026-10-02&to=2026-10-01", http.StatusBadRequest, "", ""},
+		{"Unknown format", "?from=" + today + "&format=xml", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest("GET", "/admin/usage"+tt.query, nil))
+
+			if rr.Code != tt.expectedStatus {
+				t.Fatalf("got %v want %v: %s", rr.Code, tt.expectedStatus, rr.Body.String())
+			}
+			if tt.expectedType != "" && rr.Header().Get("Content-Type") != tt.expectedType {
+				t.Errorf("expected Content-Type %q, got %q", tt.expectedType, rr.Header().Get("Content-Type"))
+			}
+			if !strings.Contains(rr.Body.String(), tt.expectedBody) {
+				t.Errorf("expected body to contain %q, got %q", tt.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-expert-rater-limit/limiter"
+)
+
+// QuotaExceededError é retornado pelo RoundTrip quando a cota de saída do
+// host acabou e a espera está desabilitada.
+type QuotaExceededError struct {
+	Host       string
+	RetryAfter time.Duration
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("outbound rate limit exceeded for %s, retry after %v", e.Host, e.RetryAfter)
+}
+
+type RateLimitedTransport struct {
+	base     http.RoundTripper
+	limiter  limiter.Limiter
+	limit    int
+	duration time.Duration
+	wait     bool
+}
+
+// NewRateLimitedTransport limita as requisições de saída a limit por duration
+// para cada host. Com um limiter sobre storage compartilhado (Redis), todas as
+// instâncias dividem a mesma cota. Com wait, RoundTrip aguarda a cota liberar
+// (respeitando o contexto da requisição) em vez de falhar.
+//
+// Quando o limiter implementa limiter.Taker a cota esgotada não bloqueia o
+// host: ela volta assim que a janela expira. Outros limiters usam Check com
+// bloqueio de uma janela, contado a partir da rejeição.
+func NewRateLimitedTransport(base http.RoundTripper, limiter limiter.Limiter, limit int, duration time.Duration, wait bool) *RateLimitedTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RateLimitedTransport{
+		base:     base,
+		limiter:  limiter,
+		limit:    limit,
+		duration: duration,
+		wait:     wait,
+	}
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := "outbound:" + req.URL.Host
+	l := limiter.WithContext(t.limiter, req.Context())
+
+	for {
+		result := t.take(l, key)
+		if result.Allowed {
+			return t.base.RoundTrip(req)
+		}
+
+		retryAfter := result.RetryAfter
+		if retryAfter <= 0 {
+			retryAfter = t.duration
+		}
+		if !t.wait {
+			return nil, &QuotaExceededError{Host: req.URL.Host, RetryAfter: retryAfter}
+		}
+
+		timer := time.NewTimer(retryAfter)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take consome uma unidade da cota do host. Um bloqueio de uma janela inteira
+// a partir da rejeição deixaria a taxa efetiva abaixo da configurada, então
+// ele só é usado quando o limiter não sabe consumir sem bloquear.
+func (t *RateLimitedTransport) take(l limiter.Limiter, key string) limiter.Result {
+	if taker, ok := l.(limiter.Taker); ok {
+		return taker.Take(key, 1, t.limit, t.duration)
+	}
+	return l.Check(key, t.limit, t.duration, t.duration)
+}
@@ -0,0 +1,117 @@
+package usage
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Formatos aceitos por Write.
+const (
+	FormatCSV       = "csv"
+	FormatJSONLines = "jsonl"
+)
+
+const (
+	contentTypeCSV   = "text/csv; charset=utf-8"
+	contentTypeJSONL = "application/jsonl"
+)
+
+// Write grava os registros em CSV (com cabeçalho) ou JSON Lines.
+func Write(w io.Writer, format string, records []Record) error {
+	switch format {
+	case FormatCSV:
+		return writeCSV(w, records)
+	case FormatJSONLines:
+		return writeJSONLines(w, records)
+	default:
+		return fmt.Errorf("usage: unsupported format %q, use csv or jsonl", format)
+	}
+}
+
+func writeCSV(w io.Writer, records []Record) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"date", "key", "allowed", "rejected"}); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{r.Date, r.Key, strconv.Itoa(r.Allowed), strconv.Itoa(r.Rejected)}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func writeJSONLines(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewExportHandler exporta o uso entre ?from= e ?to= (AAAA-MM-DD, inclusive;
+// to padrão é from) no formato de ?format= (csv, padrão, ou jsonl). Não tem
+// autenticação própria e deve ser exposto atrás de admin.RequireToken.
+func NewExportHandler(recorder *Recorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		from, err := ParseDate(query.Get("from"))
+		if err != nil {
+			http.Error(w, "from must be a date in the YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		to := from
+		if value := query.Get("to"); value != "" {
+			if to, err = ParseDate(value); err != nil {
+				http.Error(w, "to must be a date in the YYYY-MM-DD format", http.StatusBadRequest)
+				return
+			}
+		}
+		format := query.Get("format")
+		if format == "" {
+			format = FormatCSV
+		}
+		contentType := contentTypeCSV
+		switch format {
+		case FormatCSV:
+		case FormatJSONLines:
+			contentType = contentTypeJSONL
+		default:
+			http.Error(w, "format must be csv or jsonl", http.StatusBadRequest)
+			return
+		}
+
+		records, err := recorder.Report(from, to)
+		if errors.Is(err, ErrInvalidRange) || errors.Is(err, ErrRangeTooLong) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "usage storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s-%s.%s"`,
+			from.Format(dateLayout), to.Format(dateLayout), format))
+		if err := Write(w, format, records); err != nil {
+			log.Printf("Error writing usage export: %v", err)
+		}
+	})
+}
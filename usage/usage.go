@@ -0,0 +1,129 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"go-expert-rater-limit/storage"
+)
+
+// dateLayout é o formato das datas nas chaves e nos relatórios. Os dias são
+// sempre contados em UTC.
+const dateLayout = "2006-01-02"
+
+// MaxRange limita quantos dias um relatório pode cobrir.
+const MaxRange = 366
+
+var (
+	ErrFieldsUnsupported = errors.New("usage: storage does not implement storage.FieldCounter")
+	ErrInvalidRange      = errors.New("usage: from must not be after to")
+	ErrRangeTooLong      = errors.New("usage: range must not exceed 366 days")
+)
+
+// Record é o total de requisições permitidas e rejeitadas de uma chave em um
+// dia.
+type Record struct {
+	Date     string `json:"date"`
+	Key      string `json:"key"`
+	Allowed  int    `json:"allowed"`
+	Rejected int    `json:"rejected"`
+}
+
+// Recorder agrega o uso por chave e por dia no storage compartilhado: um
+// contador por chave e resultado sob usage:<data>, mantido por retention.
+type Recorder struct {
+	storage   storage.Storage
+	retention time.Duration
+}
+
+func NewRecorder(storage storage.Storage, retention time.Duration) *Recorder {
+	return &Recorder{storage: storage, retention: retention}
+}
+
+// WithContext devolve um Recorder cujas chamadas ao storage usam ctx.
+func (r *Recorder) WithContext(ctx context.Context) *Recorder {
+	return &Recorder{storage: storage.WithContext(r.storage, ctx), retention: r.retention}
+}
+
+// Record conta uma requisição da chave no dia de hoje.
+func (r *Recorder) Record(key string, allowed bool) error {
+	counter, ok := r.storage.(storage.FieldCounter)
+	if !ok {
+		return ErrFieldsUnsupported
+	}
+	outcome := "rejected"
+	if allowed {
+		outcome = "allowed"
+	}
+	return counter.IncrField(dayKey(time.Now().UTC()), outcome+":"+key, 1, r.retention)
+}
+
+// Report devolve os totais de cada chave entre from e to, ambos inclusive,
+// ordenados por data e chave.
+func (r *Recorder) Report(from, to time.Time) ([]Record, error) {
+	counter, ok := r.storage.(storage.FieldCounter)
+	if !ok {
+		return nil, ErrFieldsUnsupported
+	}
+	from, to = day(from), day(to)
+	if from.After(to) {
+		return nil, ErrInvalidRange
+	}
+	if to.Sub(from) >= MaxRange*24*time.Hour {
+		return nil, ErrRangeTooLong
+	}
+
+	var records []Record
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		fields, err := counter.GetFields(dayKey(d))
+		if err != nil {
+			return nil, err
+		}
+
+		byKey := make(map[string]*Record)
+		for field, n := range fields {
+			outcome, key, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+			record, ok := byKey[key]
+			if !ok {
+				record = &Record{Date: d.Format(dateLayout), Key: key}
+				byKey[key] = record
+			}
+			switch outcome {
+			case "allowed":
+				record.Allowed += n
+			case "rejected":
+				record.Rejected += n
+			}
+		}
+
+		keys := make([]string, 0, len(byKey))
+		for key := range byKey {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			records = append(records, *byKey[key])
+		}
+	}
+	return records, nil
+}
+
+// ParseDate interpreta uma data AAAA-MM-DD em UTC.
+func ParseDate(value string) (time.Time, error) {
+	return time.Parse(dateLayout, value)
+}
+
+func day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func dayKey(d time.Time) string {
+	return "usage:" + d.Format(dateLayout)
+}